@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -36,8 +37,11 @@ func (req Request[T]) ToRaw() Request[json.RawMessage] {
 type OperationName string
 
 const (
-	OperationNameSyncUser    = "SYNC_USER"
-	OperationNameSendMessage = "SEND_MESSAGE"
+	OperationNameSyncUser       = "SYNC_USER"
+	OperationNameSendMessage    = "SEND_MESSAGE"
+	OperationNamePurgeUser      = "PURGE_USER"
+	OperationNameDiagnoseRoles  = "DIAGNOSE_ROLES"
+	OperationNameProvisionRoles = "PROVISION_ROLES"
 )
 
 type (
@@ -45,7 +49,7 @@ type (
 )
 
 type RequestPayload interface {
-	json.RawMessage | RequestPayloadSyncUser | RequestPayloadSendMessage
+	json.RawMessage | RequestPayloadSyncUser | RequestPayloadSendMessage | RequestPayloadPurgeUser | RequestPayloadDiagnoseRoles | RequestPayloadProvisionRoles
 }
 
 type RequestPayloadSyncUser struct {
@@ -59,10 +63,80 @@ type RequestPayloadSendMessage struct {
 	Webhook bool        `json:"webhook"`
 }
 
+// RequestPayloadPurgeUser identifies a person either by their 7TV user ID or their Discord ID
+type RequestPayloadPurgeUser struct {
+	UserID    primitive.ObjectID `json:"user_id,omitempty"`
+	DiscordID string             `json:"discord_id,omitempty"`
+}
+
+// PurgeReport is the response to a PURGE_USER operation
+type PurgeReport struct {
+	UserID    primitive.ObjectID `json:"user_id,omitempty"`
+	DiscordID string             `json:"discord_id"`
+	// Deleted is the number of items deleted, by kind of data
+	Deleted map[string]int `json:"deleted"`
+}
+
+// RequestPayloadDiagnoseRoles selects the format of the role diagnostics, either "json" (the default) or "csv"
+type RequestPayloadDiagnoseRoles struct {
+	Format string `json:"format,omitempty"`
+}
+
+// RoleReport is the response to a DIAGNOSE_ROLES operation
+type RoleReport struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Roles       []RoleReportEntry `json:"roles"`
+	// Members is the number of guild members with a linked 7TV account, which the out of sync counts are based on
+	Members int `json:"members"`
+}
+
+// RoleReportEntry describes the state of a 7TV role linked to a discord role
+type RoleReportEntry struct {
+	RoleID    primitive.ObjectID `json:"role_id"`
+	Name      string             `json:"name"`
+	DiscordID string             `json:"discord_id"`
+	// Exists is whether the discord role exists in the guild
+	Exists bool `json:"exists"`
+	// Manageable is whether the bot is able to add and remove the discord role
+	Manageable bool `json:"manageable"`
+	// Reason explains why the role cannot be synced, if it can't
+	Reason string `json:"reason,omitempty"`
+	// Missing is how many members have the 7TV role but not the discord role
+	Missing int `json:"missing"`
+	// Extra is how many members have the discord role but not the 7TV role
+	Extra int `json:"extra"`
+}
+
+// RequestPayloadProvisionRoles lists the 7TV roles to create discord roles for.
+// The linked roles are restyled even when it is empty
+type RequestPayloadProvisionRoles struct {
+	RoleIDs []primitive.ObjectID `json:"role_ids"`
+}
+
+// ProvisionReport is the response to a PROVISION_ROLES operation
+type ProvisionReport struct {
+	// Created are the roles which were given a new discord role
+	Created []ProvisionedRole `json:"created"`
+	// Linked are the roles which were already linked to an existing discord role
+	Linked []ProvisionedRole `json:"linked"`
+	// Styled is the names of the roles whose discord role was renamed, recolored or moved
+	Styled []string `json:"styled"`
+}
+
+// ProvisionedRole is a 7TV role linked to a discord role
+type ProvisionedRole struct {
+	RoleID    primitive.ObjectID `json:"role_id"`
+	Name      string             `json:"name"`
+	DiscordID string             `json:"discord_id"`
+}
+
 type Instance interface {
 	SyncUser(userID primitive.ObjectID) (*http.Response, error)
 	RevokeUser(userID primitive.ObjectID) (*http.Response, error)
 	SendMessage(channel string, message MessageSend, webhook bool) (*http.Response, error)
+	PurgeUser(userID primitive.ObjectID, discordID string) (*http.Response, error)
+	DiagnoseRoles(format string) (*http.Response, error)
+	ProvisionRoles(roleIDs ...primitive.ObjectID) (*http.Response, error)
 }
 
 type cdInst struct {
@@ -119,3 +193,34 @@ func (inst *cdInst) SendMessage(channel string, message discordgo.MessageSend, w
 		},
 	}.ToRaw())
 }
+
+// PurgeUser implements Instance
+func (inst *cdInst) PurgeUser(userID primitive.ObjectID, discordID string) (*http.Response, error) {
+	return inst.request(Request[RequestPayloadPurgeUser]{
+		Operation: OperationNamePurgeUser,
+		Data: RequestPayloadPurgeUser{
+			UserID:    userID,
+			DiscordID: discordID,
+		},
+	}.ToRaw())
+}
+
+// DiagnoseRoles implements Instance
+func (inst *cdInst) DiagnoseRoles(format string) (*http.Response, error) {
+	return inst.request(Request[RequestPayloadDiagnoseRoles]{
+		Operation: OperationNameDiagnoseRoles,
+		Data: RequestPayloadDiagnoseRoles{
+			Format: format,
+		},
+	}.ToRaw())
+}
+
+// ProvisionRoles implements Instance
+func (inst *cdInst) ProvisionRoles(roleIDs ...primitive.ObjectID) (*http.Response, error) {
+	return inst.request(Request[RequestPayloadProvisionRoles]{
+		Operation: OperationNameProvisionRoles,
+		Data: RequestPayloadProvisionRoles{
+			RoleIDs: roleIDs,
+		},
+	}.ToRaw())
+}
@@ -15,12 +15,18 @@ import (
 	"github.com/seventv/common/mongo"
 	"github.com/seventv/common/redis"
 	"github.com/seventv/compactdisc/internal/api"
+	"github.com/seventv/compactdisc/internal/archive"
+	"github.com/seventv/compactdisc/internal/blob"
 	"github.com/seventv/compactdisc/internal/commands"
 	"github.com/seventv/compactdisc/internal/configure"
 	"github.com/seventv/compactdisc/internal/discord"
 	"github.com/seventv/compactdisc/internal/global"
 	"github.com/seventv/compactdisc/internal/handler"
 	"github.com/seventv/compactdisc/internal/health"
+	"github.com/seventv/compactdisc/internal/leader"
+	"github.com/seventv/compactdisc/internal/messages"
+	"github.com/seventv/compactdisc/internal/modmail"
+	"github.com/seventv/compactdisc/internal/roles"
 	"go.uber.org/zap"
 )
 
@@ -104,22 +110,168 @@ func main() {
 		zap.S().Infow("mongo, ok")
 	}
 
+	if config.Discord.AutoShard {
+		config.Discord.ShardID, err = discord.ShardFromPodName(config.K8S.PodName, config.Discord.ShardCount)
+		if err != nil {
+			zap.S().Fatalw("failed to assign shard", "error", err)
+		}
+	}
+
+	{
+		id := config.K8S.PodName
+		if id == "" {
+			id, _ = os.Hostname()
+		}
+
+		// Each shard elects its own leader
+		gctx.Inst().Leader = leader.New(gctx, gctx.Inst().Redis, leader.Options{
+			Enabled: config.Leader.Enabled,
+			Key:     gctx.Inst().Redis.ComposeKey("compactdisc", "leader", strconv.Itoa(config.Discord.ShardID)),
+			ID:      id,
+			Lease:   config.Leader.Lease,
+		})
+	}
+
+	{
+		var backend messages.Backend
+
+		switch config.Messages.Backend {
+		case "memory":
+			backend = messages.NewMemoryBackend(config.Messages.MemorySize)
+		case "mongo":
+			backend, err = messages.NewMongoBackend(gctx, gctx.Inst().Mongo, config.Messages.Collection)
+			if err != nil {
+				zap.S().Fatalw("failed to setup message cache", "error", err)
+			}
+		default:
+			backend = messages.NewRedisBackend(gctx.Inst().Redis)
+		}
+
+		var keys *messages.KeyRing
+		if config.Messages.Encryption.Enabled {
+			keys, err = messages.NewKeyRing(config.Messages.Encryption.ActiveKey, config.Messages.Encryption.Keys)
+			if err != nil {
+				zap.S().Fatalw("failed to setup message encryption", "error", err)
+			}
+		}
+
+		gctx.Inst().Messages = messages.New(backend, messages.Options{
+			Policy: messages.Policy{
+				Retention:        config.Messages.Retention,
+				Include:          config.Messages.Channels.Include,
+				Exclude:          config.Messages.Channels.Exclude,
+				ChannelRetention: config.Messages.Channels.Retention,
+				// Discord is set up later, and messages are only cached once it is
+				ParentOf: func(channelID string) string {
+					return messages.ThreadParent(gctx.Inst().Discord.Session())(channelID)
+				},
+			},
+			Compress: config.Messages.Compress,
+			Keys:     keys,
+		})
+
+		// Re-encode existing entries, i.e after enabling encryption or rotating the active key
+		if config.Messages.Encryption.Migrate {
+			gctx.Inst().Leader.OnElected(func() {
+				n, err := gctx.Inst().Messages.Migrate(gctx)
+				if err != nil {
+					zap.S().Errorw("failed to migrate message cache", "error", err, "migrated", n)
+					return
+				}
+
+				zap.S().Infow("message cache migrated", "migrated", n)
+			})
+		}
+
+		zap.S().Infow("message cache, ok", "backend", config.Messages.Backend, "encrypted", keys != nil)
+	}
+
+	if config.Archive.Enabled {
+		gctx.Inst().Blob, err = blob.New(blob.Options{
+			Kind: blob.Kind(config.Archive.Store.Kind),
+			Path: config.Archive.Store.Path,
+			S3: blob.S3Options{
+				Endpoint:  config.Archive.Store.S3.Endpoint,
+				Region:    config.Archive.Store.S3.Region,
+				Bucket:    config.Archive.Store.S3.Bucket,
+				AccessKey: config.Archive.Store.S3.AccessKey,
+				SecretKey: config.Archive.Store.S3.SecretKey,
+				PathStyle: config.Archive.Store.S3.PathStyle,
+			},
+		})
+		if err != nil {
+			zap.S().Fatalw("failed to setup blob store", "error", err)
+		}
+
+		// Archived attachments expire along with their cached message
+		go archive.Expire(gctx)
+
+		zap.S().Infow("blob store, ok", "kind", config.Archive.Store.Kind)
+	}
+
+	{
+		gctx.Inst().Query = query.New(gctx.Inst().Mongo, gctx.Inst().Redis)
+	}
+
 	{
-		gctx.Inst().Discord, err = discord.New(gctx, config.Discord.Token)
+		gctx.Inst().Discord, err = discord.New(gctx, discord.Options{
+			Token:      config.Discord.Token,
+			ShardID:    config.Discord.ShardID,
+			ShardCount: config.Discord.ShardCount,
+		})
 		if err != nil {
 			zap.S().Fatalw("failed to setup discord", "error", err)
 		}
 
+		gctx.Inst().Roles = roles.New(gctx, gctx.Inst().Mongo, gctx.Inst().Query, gctx.Inst().Discord.Session())
+
+		if config.Modmail.Enabled {
+			gctx.Inst().Modmail, err = modmail.New(gctx, gctx.Inst().Mongo, gctx.Inst().Query, gctx.Inst().Leader, gctx.Inst().Discord.Session(), modmail.Options{
+				Channel:    config.Modmail.Channel,
+				WebsiteURL: config.WebsiteURL,
+			})
+			if err != nil {
+				zap.S().Fatalw("failed to setup modmail", "error", err)
+			}
+		}
+
+		// Index the messages cached before they were indexed by author, so that they can be purged.
+		// This only needs to happen once, which is recorded in redis. Retention depends on the parent of threads,
+		// so the shard owning the guild waits for its state to be loaded first
+		if gctx.Inst().Discord.OwnsGuild(config.Discord.GuildID) {
+			gctx.Inst().Leader.OnElected(func() {
+				marker := gctx.Inst().Redis.ComposeKey("compactdisc", "cache", "authors-indexed")
+				if v, _ := gctx.Inst().Redis.Get(gctx, marker); v != "" {
+					return
+				}
+
+				if err := gctx.Inst().Discord.WaitGuild(gctx, config.Discord.GuildID); err != nil {
+					return
+				}
+
+				n, err := gctx.Inst().Messages.IndexAuthors(gctx)
+				if err != nil {
+					zap.S().Errorw("failed to index cached messages by author", "error", err, "indexed", n)
+					return
+				}
+
+				if err := gctx.Inst().Redis.Set(gctx, marker, "1"); err != nil {
+					zap.S().Warnw("failed to record the author index backfill", "error", err)
+				}
+
+				zap.S().Infow("cached messages indexed by author", "indexed", n)
+			})
+		}
+
 		handler.Register(gctx, gctx.Inst().Discord.Session())
 		if err := commands.Setup(gctx); err != nil {
 			zap.S().Fatalw("failed to setup commands", "error", err)
 		}
 
-		zap.S().Infow("discord, ok")
-	}
-
-	{
-		gctx.Inst().Query = query.New(gctx.Inst().Mongo, gctx.Inst().Redis)
+		zap.S().Infow("discord, ok",
+			"shard_id", gctx.Inst().Discord.ShardID(),
+			"shard_count", gctx.Inst().Discord.ShardCount(),
+		)
 	}
 
 	wg := sync.WaitGroup{}
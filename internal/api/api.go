@@ -40,6 +40,18 @@ func Start(gctx global.Context) (<-chan uint8, error) {
 				return
 			}
 
+			// Operations act on the configured guild, so they must run on the shard which owns it
+			if shardID, ok := shouldForward(gctx, gctx.Config().Discord.GuildID); ok {
+				zap.S().Infow("forwarding operation", "operation", body.Operation, "shard_id", shardID)
+
+				if err := forward(gctx, ctx, shardID); err != nil {
+					_, _ = ctx.WriteString(err.Error())
+					ctx.SetStatusCode(fasthttp.StatusBadGateway)
+				}
+
+				return
+			}
+
 			zap.S().Infow("executing operation", "operation", body.Operation)
 
 			switch body.Operation {
@@ -47,6 +59,12 @@ func Start(gctx global.Context) (<-chan uint8, error) {
 				err = operations.SyncUser(gctx, ctx, compactdisc.ConvertRequest[compactdisc.RequestPayloadSyncUser](body))
 			case compactdisc.OperationNameSendMessage:
 				err = operations.SendMessage(gctx, ctx, compactdisc.ConvertRequest[compactdisc.RequestPayloadSendMessage](body))
+			case compactdisc.OperationNamePurgeUser:
+				err = operations.PurgeUser(gctx, ctx, compactdisc.ConvertRequest[compactdisc.RequestPayloadPurgeUser](body))
+			case compactdisc.OperationNameDiagnoseRoles:
+				err = operations.DiagnoseRoles(gctx, ctx, compactdisc.ConvertRequest[compactdisc.RequestPayloadDiagnoseRoles](body))
+			case compactdisc.OperationNameProvisionRoles:
+				err = operations.ProvisionRoles(gctx, ctx, compactdisc.ConvertRequest[compactdisc.RequestPayloadProvisionRoles](body))
 			}
 
 			if err != nil {
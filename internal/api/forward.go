@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/seventv/compactdisc/internal/discord"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/valyala/fasthttp"
+)
+
+// forwardedHeader marks requests which were already forwarded by another shard
+const forwardedHeader = "X-CompactDisc-Forwarded-By"
+
+var forwardClient = &fasthttp.Client{
+	ReadTimeout:  time.Second * 20,
+	WriteTimeout: time.Second * 20,
+}
+
+// shouldForward returns the shard owning a guild if the request must be handled by another shard
+func shouldForward(gctx global.Context, guildID string) (int, bool) {
+	dis := gctx.Inst().Discord
+	if dis.OwnsGuild(guildID) {
+		return 0, false
+	}
+
+	return discord.ShardOf(guildID, dis.ShardCount()), true
+}
+
+// forward relays a request to the shard owning the guild and copies back its response
+func forward(gctx global.Context, ctx *fasthttp.RequestCtx, shardID int) error {
+	if len(ctx.Request.Header.Peek(forwardedHeader)) > 0 {
+		return fmt.Errorf("request was forwarded to shard %d, which does not own the guild", gctx.Inst().Discord.ShardID())
+	}
+
+	if gctx.Config().Discord.ShardAddress == "" {
+		return fmt.Errorf("guild is owned by shard %d, but no shard address is configured", shardID)
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	ctx.Request.CopyTo(req)
+	req.SetRequestURI(fmt.Sprintf(gctx.Config().Discord.ShardAddress, shardID))
+	req.Header.Set(forwardedHeader, fmt.Sprint(gctx.Inst().Discord.ShardID()))
+
+	if err := forwardClient.Do(req, resp); err != nil {
+		return err
+	}
+
+	resp.CopyTo(&ctx.Response)
+
+	return nil
+}
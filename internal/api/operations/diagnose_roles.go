@@ -0,0 +1,37 @@
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/seventv/compactdisc"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"github.com/valyala/fasthttp"
+)
+
+func DiagnoseRoles(gctx global.Context, ctx *fasthttp.RequestCtx, req compactdisc.Request[compactdisc.RequestPayloadDiagnoseRoles]) error {
+	report, err := rolesync.Diagnose(gctx, ctx)
+	if err != nil {
+		return err
+	}
+
+	switch req.Data.Format {
+	case "", "json":
+		ctx.SetContentType("application/json")
+
+		return json.NewEncoder(ctx).Encode(report)
+	case "csv":
+		b, err := rolesync.ReportCSV(report)
+		if err != nil {
+			return err
+		}
+
+		ctx.SetContentType("text/csv")
+		_, err = ctx.Write(b)
+
+		return err
+	default:
+		return fmt.Errorf("unknown format %q", req.Data.Format)
+	}
+}
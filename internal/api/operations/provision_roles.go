@@ -0,0 +1,21 @@
+package operations
+
+import (
+	"encoding/json"
+
+	"github.com/seventv/compactdisc"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"github.com/valyala/fasthttp"
+)
+
+func ProvisionRoles(gctx global.Context, ctx *fasthttp.RequestCtx, req compactdisc.Request[compactdisc.RequestPayloadProvisionRoles]) error {
+	report, err := rolesync.Provision(gctx, ctx, req.Data.RoleIDs)
+	if err != nil {
+		return err
+	}
+
+	ctx.SetContentType("application/json")
+
+	return json.NewEncoder(ctx).Encode(report)
+}
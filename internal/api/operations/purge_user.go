@@ -0,0 +1,21 @@
+package operations
+
+import (
+	"encoding/json"
+
+	"github.com/seventv/compactdisc"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/purge"
+	"github.com/valyala/fasthttp"
+)
+
+func PurgeUser(gctx global.Context, ctx *fasthttp.RequestCtx, req compactdisc.Request[compactdisc.RequestPayloadPurgeUser]) error {
+	report, err := purge.User(gctx, req.Data.DiscordID, req.Data.UserID)
+	if err != nil {
+		return err
+	}
+
+	ctx.SetContentType("application/json")
+
+	return json.NewEncoder(ctx).Encode(report)
+}
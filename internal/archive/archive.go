@@ -0,0 +1,273 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-redis/redis/v8"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/blob"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/messages"
+	"go.uber.org/zap"
+)
+
+const (
+	// uploadLimit is the maximum total size of the files re-uploaded to discord with a single message
+	uploadLimit = 25 << 20
+	// sweepInterval is how often expired attachments are deleted
+	sweepInterval = time.Minute * 10
+	// sweepBatch is how many expired attachments are deleted at once
+	sweepBatch = 500
+)
+
+var httpClient = &http.Client{
+	Timeout: time.Minute,
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Enabled returns whether attachments sent in a channel are archived
+func Enabled(gctx global.Context, channelID string) bool {
+	return gctx.Inst().Blob != nil && utils.Contains(gctx.Config().Archive.Channels, channelID)
+}
+
+// Key returns the key an attachment is archived under
+func Key(msg *discordgo.Message, a *discordgo.MessageAttachment) string {
+	return fmt.Sprintf("attachments/%s/%s/%s", msg.ID, a.ID, unsafeChars.ReplaceAllString(a.Filename, "_"))
+}
+
+// Store downloads the attachments of a message and archives them
+func Store(gctx global.Context, msg *discordgo.Message) {
+	if !Enabled(gctx, msg.ChannelID) {
+		return
+	}
+
+	maxSize := gctx.Config().Archive.MaxSize
+
+	for _, a := range msg.Attachments {
+		z := zap.S().Named("archive").With(
+			"message_id", msg.ID,
+			"attachment_id", a.ID,
+		)
+
+		if maxSize > 0 && int64(a.Size) > maxSize {
+			z.Debugw("attachment is too large to be archived", "size", a.Size)
+			continue
+		}
+
+		if err := store(gctx, msg, a, maxSize); err != nil {
+			z.Errorw("failed to archive attachment", "error", err)
+		}
+	}
+}
+
+func store(gctx global.Context, msg *discordgo.Message, a *discordgo.MessageAttachment, maxSize int64) error {
+	ctx, cancel := context.WithTimeout(gctx, time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("attachment download responded with %s", resp.Status)
+	}
+
+	// Guard against the reported size being wrong
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	if maxSize > 0 && int64(len(b)) > maxSize {
+		return fmt.Errorf("attachment exceeds the maximum size of %d bytes", maxSize)
+	}
+
+	key := Key(msg, a)
+
+	if err := gctx.Inst().Blob.Put(ctx, key, bytes.NewReader(b), int64(len(b)), a.ContentType); err != nil {
+		return err
+	}
+
+	// Attachments are kept for as long as their message is cached, after which the sweep deletes them
+	expiry := time.Now().Add(retentionOf(gctx, msg.ChannelID))
+
+	return gctx.Inst().Redis.RawClient().ZAdd(ctx, expiriesKey(gctx), &redis.Z{
+		Score:  float64(expiry.Unix()),
+		Member: key,
+	}).Err()
+}
+
+// retentionOf returns how long the messages of a channel are cached, which archived attachments share
+func retentionOf(gctx global.Context, channelID string) time.Duration {
+	policy := messages.Policy{
+		Retention:        gctx.Config().Messages.Retention,
+		ChannelRetention: gctx.Config().Messages.Channels.Retention,
+		ParentOf:         messages.ThreadParent(gctx.Inst().Discord.Session()),
+	}
+
+	return policy.RetentionOf(channelID)
+}
+
+// expiriesKey is the sorted set of archived keys, scored by when they expire
+func expiriesKey(gctx global.Context) string {
+	return gctx.Inst().Redis.ComposeKey("compactdisc", "archive", "expiries").String()
+}
+
+// Files retrieves the archived attachments of a message, ready to be uploaded
+func Files(gctx global.Context, msg *discordgo.Message) []*discordgo.File {
+	if gctx.Inst().Blob == nil {
+		return nil
+	}
+
+	files := []*discordgo.File{}
+	total := 0
+
+	for _, a := range msg.Attachments {
+		b, err := get(gctx, Key(msg, a))
+		if err != nil {
+			if err != blob.ErrNotFound {
+				zap.S().Named("archive").Errorw("failed to retrieve archived attachment", "error", err, "attachment_id", a.ID)
+			}
+
+			continue
+		}
+
+		if total+len(b) > uploadLimit {
+			break
+		}
+
+		total += len(b)
+
+		files = append(files, &discordgo.File{
+			Name:        a.Filename,
+			ContentType: a.ContentType,
+			Reader:      bytes.NewReader(b),
+		})
+	}
+
+	return files
+}
+
+func get(gctx global.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(gctx, time.Minute)
+	defer cancel()
+
+	r, err := gctx.Inst().Blob.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// Delete removes the archived attachments of a message
+func Delete(gctx global.Context, msg *discordgo.Message) (int, error) {
+	if gctx.Inst().Blob == nil {
+		return 0, nil
+	}
+
+	count := 0
+
+	for _, a := range msg.Attachments {
+		key := Key(msg, a)
+
+		if err := gctx.Inst().Blob.Delete(gctx, key); err != nil {
+			return count, err
+		}
+
+		if err := gctx.Inst().Redis.RawClient().ZRem(gctx, expiriesKey(gctx), key).Err(); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// Sweep deletes the attachments whose message has expired from the cache, returning how many were deleted
+func Sweep(gctx global.Context, ctx context.Context) (int, error) {
+	if gctx.Inst().Blob == nil {
+		return 0, nil
+	}
+
+	cl := gctx.Inst().Redis.RawClient()
+	key := expiriesKey(gctx)
+	count := 0
+
+	for {
+		keys, err := cl.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().Unix(), 10),
+			Count: sweepBatch,
+		}).Result()
+		if err != nil {
+			return count, err
+		}
+
+		for _, k := range keys {
+			if err := gctx.Inst().Blob.Delete(ctx, k); err != nil {
+				return count, err
+			}
+
+			if err := cl.ZRem(ctx, key, k).Err(); err != nil {
+				return count, err
+			}
+
+			count++
+		}
+
+		if len(keys) < sweepBatch {
+			return count, nil
+		}
+	}
+}
+
+// Expire periodically sweeps expired attachments while this replica is the leader, until the context is done
+func Expire(gctx global.Context) {
+	tick := time.NewTicker(sweepInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-gctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		if !gctx.Inst().Leader.IsLeader() {
+			continue
+		}
+
+		n, err := Sweep(gctx, gctx)
+		if err != nil {
+			zap.S().Named("archive").Errorw("failed to sweep expired attachments", "error", err, "deleted", n)
+		} else if n > 0 {
+			zap.S().Named("archive").Infow("expired attachments deleted", "deleted", n)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned when an object does not exist in the store
+var ErrNotFound = errors.New("object not found")
+
+// Store is a key-value store for binary objects
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type Kind string
+
+const (
+	KindLocal  Kind = "local"
+	KindS3     Kind = "s3"
+	KindMemory Kind = "memory"
+)
+
+type Options struct {
+	Kind Kind
+	// Path is the directory objects are stored in, for the local store
+	Path string
+	S3   S3Options
+}
+
+func New(opt Options) (Store, error) {
+	switch opt.Kind {
+	case KindLocal:
+		return NewLocal(opt.Path)
+	case KindS3:
+		return NewS3(opt.S3)
+	case KindMemory:
+		return NewMemory(), nil
+	}
+
+	return nil, fmt.Errorf("unknown blob store kind %q", opt.Kind)
+}
@@ -0,0 +1,65 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testStore checks the behavior every store shares
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	key := "attachments/1/2/file.txt"
+
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing object, got %v", err)
+	}
+
+	if err := store.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(r)
+	r.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "hello" {
+		t.Fatalf("expected the stored object, got %q", b)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a deleted object, got %v", err)
+	}
+
+	// Deleting what no longer exists is not an error, so that sweeps and purges can be retried
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("expected deleting a missing object to succeed, got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
@@ -0,0 +1,53 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+type memoryStore struct {
+	mx      sync.RWMutex
+	objects map[string][]byte
+}
+
+// NewMemory creates a store which keeps objects in memory, for development and tests
+func NewMemory() Store {
+	return &memoryStore{
+		objects: make(map[string][]byte),
+	}
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	s.objects[key] = b
+	s.mx.Unlock()
+
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mx.RLock()
+	b, ok := s.objects[key]
+	s.mx.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mx.Lock()
+	delete(s.objects, key)
+	s.mx.Unlock()
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type S3Options struct {
+	// Endpoint is the base URL of the S3-compatible API, such as https://s3.us-east-1.amazonaws.com or http://localhost:9000 for MinIO
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path rather than as a subdomain, which MinIO requires
+	PathStyle bool
+}
+
+type s3Store struct {
+	opt      S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 creates a store backed by an S3-compatible object storage
+func NewS3(opt S3Options) (Store, error) {
+	if opt.Bucket == "" {
+		return nil, errors.New("s3 blob store requires a bucket")
+	}
+
+	if opt.Region == "" {
+		opt.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(opt.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Store{
+		opt:      opt,
+		endpoint: endpoint,
+		client: &http.Client{
+			Timeout: time.Minute,
+		},
+	}, nil
+}
+
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+
+	if s.opt.PathStyle {
+		u.Path = "/" + s.opt.Bucket + "/" + key
+	} else {
+		u.Host = s.opt.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+
+	return &u
+}
+
+func (s *s3Store) do(ctx context.Context, method string, key string, body []byte, contentType string) (*http.Response, error) {
+	u := s.objectURL(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// The path sent must match the canonical one which is signed
+	req.URL.RawPath = uriEncode(req.URL.Path)
+	req.ContentLength = int64(len(body))
+	s.sign(req, body, time.Now().UTC())
+
+	return s.client.Do(req)
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 authorization to a request
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	}
+
+	canonicalHeaders := strings.Builder{}
+	for _, h := range signedHeaders {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.opt.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.opt.SecretKey), date)
+	key = hmacSHA256(key, s.opt.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opt.AccessKey, scope, strings.Join(signedHeaders, ";"), signature,
+	))
+}
+
+// canonicalQuery encodes a query string as specified by SigV4, sorted by name and with every value set
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, uriEncodeComponent(name)+"="+uriEncodeComponent(v))
+		}
+	}
+
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode encodes a path as specified by SigV4, keeping slashes
+func uriEncode(path string) string {
+	return encode(path, true)
+}
+
+// uriEncodeComponent encodes a query parameter as specified by SigV4, including slashes
+func uriEncodeComponent(s string) string {
+	return encode(s, false)
+}
+
+func encode(s string, keepSlash bool) string {
+	sb := strings.Builder{}
+
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' || (keepSlash && b == '/') {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+
+	return sb.String()
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+func s3Error(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 responded with %s: %s", resp.Status, string(b))
+}
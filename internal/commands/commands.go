@@ -3,12 +3,20 @@ package commands
 import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/seventv/compactdisc/internal/global"
-	"go.uber.org/zap"
+	"github.com/seventv/compactdisc/internal/handler"
+	"github.com/seventv/compactdisc/internal/leader"
+	"github.com/seventv/compactdisc/internal/notes"
 )
 
 type Command struct {
 	Data    *discordgo.ApplicationCommand
 	Handler CommandHandler
+	// Subcommands handle the subcommands of the command by name, instead of Handler
+	Subcommands map[string]CommandHandler
+	// Autocomplete suggests values for the options of the command which have autocompletion enabled
+	Autocomplete CommandHandler
+	// Middleware wraps the handlers of the command
+	Middleware []Middleware
 }
 
 func DefineCommand(data *discordgo.ApplicationCommand, handler CommandHandler) *Command {
@@ -25,39 +33,58 @@ func Setup(gctx global.Context) error {
 	appID := gctx.Inst().Discord.Identity().ID
 	guildID := gctx.Config().Discord.GuildID
 
-	registeredCommands, _ := disc.ApplicationCommands(appID, guildID)
-	for _, cmd := range registeredCommands {
-		_ = disc.ApplicationCommandDelete(appID, guildID, cmd.ID)
+	if err := setupLookups(gctx); err != nil {
+		return err
+	}
+
+	if err := notes.Setup(gctx, gctx); err != nil {
+		return err
 	}
 
 	commands := []*Command{
 		UserInfo(gctx, appID, guildID),
+		ViewHistory(gctx, appID, guildID),
+		RecentMessages(gctx, appID, guildID),
+		Purge(gctx, appID, guildID),
+		Modmail(gctx, appID, guildID),
+		Emote(gctx, appID, guildID),
+		User(gctx, appID, guildID),
+		SyncRoles(gctx, appID, guildID),
+		Roles(gctx, appID, guildID),
+		Notes(gctx, appID, guildID),
 	}
 
-	for _, cmd := range commands {
-		_, err := disc.ApplicationCommandCreate(appID, gctx.Config().Discord.GuildID, cmd.Data)
-		if err != nil {
-			zap.S().Errorw("failed to setup commands", "error", err)
+	// Commands are only registered by the leader of the shard owning their guild, so replicas don't race each other.
+	// Global commands are registered by the first shard
+	for scope, defined := range scopes(commands) {
+		scope, defined := scope, defined
+
+		owned := gctx.Inst().Discord.ShardID() == 0
+		if scope != "" {
+			owned = gctx.Inst().Discord.OwnsGuild(scope)
+		}
+
+		if owned {
+			gctx.Inst().Leader.OnElected(func() {
+				register(gctx, appID, scope, defined)
+			})
 		}
+	}
+
+	router := NewRouter()
+	router.Use(Recover())
 
-		disc.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			if err := cmd.Handler(s, i); err != nil {
-				zap.S().Errorw("failed to handle command", "command", cmd.Data.Name, "error", err)
-
-				err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content:         err.Error(),
-						AllowedMentions: &discordgo.MessageAllowedMentions{},
-						Flags:           discordgo.MessageFlagsEphemeral,
-					},
-				})
-				if err != nil {
-					zap.S().Errorw("failed to respond to command about the failure to handle the command", "error", err)
-				}
-			}
-		})
+	for _, cmd := range commands {
+		router.Add(cmd)
 	}
 
+	router.Component(pagePrefix+":", func(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+		return handlePageButton(gctx, s, i)
+	})
+
+	router.Component(handler.UnfurlDismissPrefix, handler.UnfurlDismiss(gctx))
+
+	disc.AddHandler(leader.Only(gctx.Inst().Leader, router.Handle))
+
 	return nil
 }
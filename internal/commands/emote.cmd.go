@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/api/data/query"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// emoteSearchLimit is how many emotes a search returns at most
+	emoteSearchLimit = 25
+	// emoteAutocompleteLimit is how many choices discord accepts for autocompletion
+	emoteAutocompleteLimit = 25
+	// emoteAutocompleteDelay is how long a member must stop typing for before emotes are looked up
+	emoteAutocompleteDelay = time.Millisecond * 300
+)
+
+// Emote searches 7TV emotes
+func Emote(gctx global.Context, appID string, guildID string) *Command {
+	typing := newDebouncer()
+
+	cmd := DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID: appID,
+			GuildID:       guildID,
+			Type:          discordgo.ChatApplicationCommand,
+			Name:          "emote",
+			Description:   "Search 7TV emotes",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "name",
+					Description:  "The name of the emote",
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "owner",
+					Description: "The username of the emote's owner",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "tags",
+					Description: "Tags the emote must have, separated by commas",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "listed",
+					Description: "Only show listed or unlisted emotes",
+				},
+			},
+		},
+		func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			name := ""
+			filter := bson.M{}
+
+			for _, opt := range interaction.ApplicationCommandData().Options {
+				switch opt.Name {
+				case "name":
+					name = strings.TrimSpace(opt.StringValue())
+				case "owner":
+					owner, err := gctx.Inst().Query.Users(gctx, bson.M{"username": strings.ToLower(opt.StringValue())}).First()
+					if err != nil {
+						return respondEphemeral(session, interaction, fmt.Sprintf("No user named %s was found", opt.StringValue()))
+					}
+
+					filter["owner_id"] = owner.ID
+				case "tags":
+					tags := []string{}
+
+					for _, tag := range strings.Split(opt.StringValue(), ",") {
+						if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+							tags = append(tags, tag)
+						}
+					}
+
+					if len(tags) > 0 {
+						filter["tags"] = bson.M{"$all": tags}
+					}
+				case "listed":
+					filter["versions.state.listed"] = opt.BoolValue()
+				}
+			}
+
+			// Names are matched regardless of case, like on the website
+			emotes, _, err := gctx.Inst().Query.SearchEmotes(gctx, query.SearchEmotesOptions{
+				Query: name,
+				Page:  1,
+				Limit: emoteSearchLimit,
+				Filter: &query.SearchEmotesFilter{
+					IgnoreTags: utils.PointerOf(true),
+					Document:   filter,
+				},
+				Sort: bson.M{"versions.state.channel_count": -1},
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(emotes) == 0 {
+				return respondEphemeral(session, interaction, "No emote matched the search")
+			}
+
+			ownerIDs := make([]primitive.ObjectID, len(emotes))
+			for i, emote := range emotes {
+				ownerIDs[i] = emote.OwnerID
+			}
+
+			owners := map[primitive.ObjectID]structures.User{}
+			if users, err := gctx.Inst().Query.Users(gctx, bson.M{"_id": bson.M{"$in": ownerIDs}}).Items(); err == nil {
+				for _, u := range users {
+					owners[u.ID] = u
+				}
+			}
+
+			pages := make([]*discordgo.MessageEmbed, len(emotes))
+			for i, emote := range emotes {
+				pages[i] = emoteResultEmbed(gctx, emote, owners, i, len(emotes))
+			}
+
+			return respondPages(gctx, session, interaction, pages, false)
+		},
+	)
+
+	cmd.Autocomplete = func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+		value := ""
+
+		for _, opt := range interaction.ApplicationCommandData().Options {
+			if opt.Name == "name" && opt.Focused {
+				value = opt.StringValue()
+			}
+		}
+
+		choices := []*discordgo.ApplicationCommandOptionChoice{}
+
+		// Discord asks for choices on every keystroke, only the last of which is worth looking up
+		if value != "" && typing.settle(interaction.Member.User.ID, interaction.ID, emoteAutocompleteDelay) {
+			emotes, _, err := gctx.Inst().Query.SearchEmotes(gctx, query.SearchEmotesOptions{
+				Query: value,
+				Page:  1,
+				Limit: emoteAutocompleteLimit * 2, // leave room for duplicate names
+				Filter: &query.SearchEmotesFilter{
+					IgnoreTags: utils.PointerOf(true),
+				},
+				Sort: bson.M{"versions.state.channel_count": -1},
+			})
+			if err != nil {
+				return err
+			}
+
+			seen := map[string]bool{}
+
+			for _, emote := range emotes {
+				if seen[emote.Name] || len(choices) == emoteAutocompleteLimit {
+					continue
+				}
+
+				seen[emote.Name] = true
+				choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+					Name:  emote.Name,
+					Value: emote.Name,
+				})
+			}
+		}
+
+		return session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+	}
+
+	return cmd
+}
+
+// emoteResultEmbed renders an emote found by a search
+func emoteResultEmbed(gctx global.Context, emote structures.Emote, owners map[primitive.ObjectID]structures.User, index int, total int) *discordgo.MessageEmbed {
+	var version structures.EmoteVersion
+	if len(emote.Versions) > 0 {
+		version = emote.Versions[0]
+	}
+
+	listed := "Unlisted"
+	if version.State.Listed {
+		listed = "Listed"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:     emote.Name,
+		URL:       fmt.Sprintf("%s/emotes/%s", gctx.Config().WebsiteURL, emote.ID.Hex()),
+		Color:     0x29B6F6,
+		Timestamp: emote.ID.Timestamp().Format(time.RFC3339),
+		Image: &discordgo.MessageEmbedImage{
+			URL: fmt.Sprintf("https://%s/emote/%s/4x.webp", gctx.Config().CdnURL, emote.ID.Hex()),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Listing",
+				Value:  listed,
+				Inline: true,
+			},
+			{
+				Name:   "Channels",
+				Value:  fmt.Sprintf("%d", version.State.ChannelCount),
+				Inline: true,
+			},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Result %d of %d", index+1, total),
+		},
+	}
+
+	if owner, ok := owners[emote.OwnerID]; ok {
+		embed.Author = &discordgo.MessageEmbedAuthor{
+			Name: owner.DisplayName,
+			URL:  owner.WebURL(gctx.Config().WebsiteURL),
+		}
+	}
+
+	if len(emote.Tags) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Tags",
+			Value: transcript.Truncate(strings.Join(emote.Tags, ", "), 1024),
+		})
+	}
+
+	return embed
+}
+
+// debouncer tells apart the last of a burst of requests, such as the autocompletions of a member typing
+type debouncer struct {
+	mx     sync.Mutex
+	latest map[string]string
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{
+		latest: map[string]string{},
+	}
+}
+
+// settle waits for a delay, then returns whether no other request of the same key came in meanwhile
+func (d *debouncer) settle(key string, id string, delay time.Duration) bool {
+	d.mx.Lock()
+	d.latest[key] = id
+	d.mx.Unlock()
+
+	time.Sleep(delay)
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.latest[key] != id {
+		return false
+	}
+
+	delete(d.latest, key)
+
+	return true
+}
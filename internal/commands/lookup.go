@@ -0,0 +1,210 @@
+package commands
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// collectionLookups records who looked up which user, as lookups can reveal private information
+const collectionLookups mongo.CollectionName = "compactdisc_user_lookups"
+
+// defaultLookupRetention is how long lookups are kept for when no retention is configured
+const defaultLookupRetention = 90 * 24 * time.Hour
+
+// The fields of User Info which are only shown to sufficiently privileged staff
+const (
+	fieldConnections = "connections"
+	fieldEditors     = "editors"
+	fieldBans        = "bans"
+	fieldNotes       = "notes"
+)
+
+// defaultFieldPermissions are the 7TV permissions required to see the sensitive fields which have no configured rule
+var defaultFieldPermissions = map[string]structures.RolePermission{
+	fieldConnections: structures.RolePermissionManageUsers,
+	fieldEditors:     structures.RolePermissionManageUsers,
+	fieldBans:        structures.RolePermissionManageBans,
+	fieldNotes:       structures.RolePermissionManageUsers,
+}
+
+// fieldAccess is whether the viewer of User Info may see each sensitive field
+type fieldAccess map[string]bool
+
+// hidden returns the sensitive fields the viewer may not see
+func (a fieldAccess) hidden() []string {
+	result := []string{}
+
+	for field := range defaultFieldPermissions {
+		if !a[field] {
+			result = append(result, field)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// viewerAccess resolves which sensitive fields a moderator may see, from the roles and permissions of their 7TV account.
+// Moderators without a linked 7TV account see none of them
+func viewerAccess(gctx global.Context, member *discordgo.Member) (fieldAccess, structures.User) {
+	access := fieldAccess{}
+
+	if member == nil || member.User == nil {
+		return access, structures.User{}
+	}
+
+	viewer, err := rolesync.LinkedUser(gctx, gctx, member.User.ID)
+	if err != nil {
+		if !errors.Is(err, rolesync.ErrNotLinked) {
+			zap.S().Errorw("failed to get the 7TV account of a moderator", "error", err, "discord_id", member.User.ID)
+		}
+
+		return access, structures.User{}
+	}
+
+	roleIDs := make(map[string]struct{}, len(viewer.Roles))
+	for _, rol := range viewer.Roles {
+		roleIDs[rol.ID.Hex()] = struct{}{}
+	}
+
+	for field, perm := range defaultFieldPermissions {
+		rule, ok := gctx.Config().UserInfo.Fields[field]
+		if !ok {
+			access[field] = viewer.HasPermission(perm)
+			continue
+		}
+
+		if rule.Permissions != 0 && viewer.HasPermission(structures.RolePermission(rule.Permissions)) {
+			access[field] = true
+			continue
+		}
+
+		for _, roleID := range rule.Roles {
+			if _, ok := roleIDs[roleID]; ok {
+				access[field] = true
+				break
+			}
+		}
+	}
+
+	return access, viewer
+}
+
+// lookup is an entry of the audit of user lookups
+type lookup struct {
+	ID primitive.ObjectID `bson:"_id"`
+	// ModeratorID is the discord ID of the moderator who looked the user up
+	ModeratorID string `bson:"moderator_id"`
+	// ModeratorUserID is the 7TV account of the moderator, if they have one
+	ModeratorUserID primitive.ObjectID `bson:"moderator_user_id,omitempty"`
+	// UserID is the 7TV user which was looked up, unset when none was found
+	UserID primitive.ObjectID `bson:"user_id,omitempty"`
+	// Command is the name of the command used
+	Command string `bson:"command"`
+	// Query is what was looked up, i.e a username or a discord ID
+	Query string `bson:"query"`
+	// Hidden are the sensitive fields which weren't shown to the moderator
+	Hidden []string  `bson:"hidden"`
+	At     time.Time `bson:"at"`
+}
+
+// setupLookups creates the indexes of the audit of user lookups. Entries expire after the configured retention,
+// and the index expiring them is rebuilt when the retention changes
+func setupLookups(gctx global.Context) error {
+	retention := gctx.Config().UserInfo.LookupRetention
+	if retention <= 0 {
+		retention = defaultLookupRetention
+	}
+
+	coll := gctx.Inst().Mongo.Collection(collectionLookups)
+
+	ttl := mongodriver.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetName("at_ttl").SetExpireAfterSeconds(int32(retention / time.Second)),
+	}
+
+	if _, err := coll.Indexes().CreateOne(gctx, ttl); isIndexConflict(err) {
+		if _, err := coll.Indexes().DropOne(gctx, "at_ttl"); err != nil {
+			return err
+		}
+
+		if _, err := coll.Indexes().CreateOne(gctx, ttl); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	_, err := coll.Indexes().CreateMany(gctx, []mongodriver.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "moderator_id", Value: 1}, {Key: "at", Value: -1}}},
+	})
+
+	return err
+}
+
+func isIndexConflict(err error) bool {
+	var cmdErr mongodriver.CommandError
+
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Code == 86) // IndexOptionsConflict, IndexKeySpecsConflict
+}
+
+// recordLookup adds a user lookup to the audit
+func recordLookup(gctx global.Context, i *discordgo.InteractionCreate, viewer structures.User, userID primitive.ObjectID, query string, hidden []string) error {
+	moderatorID := ""
+	if i.Member != nil && i.Member.User != nil {
+		moderatorID = i.Member.User.ID
+	}
+
+	_, err := gctx.Inst().Mongo.Collection(collectionLookups).InsertOne(gctx, lookup{
+		ID:              primitive.NewObjectID(),
+		ModeratorID:     moderatorID,
+		ModeratorUserID: viewer.ID,
+		UserID:          userID,
+		Command:         i.ApplicationCommandData().Name,
+		Query:           query,
+		Hidden:          hidden,
+		At:              time.Now(),
+	})
+
+	return err
+}
+
+// respondUserInfo responds with the information about a 7TV user, redacted for the moderator who asked for it.
+// The lookup is recorded first, and nothing is shown if that fails
+func respondUserInfo(gctx global.Context, s *discordgo.Session, i *discordgo.InteractionCreate, user structures.User, query string) error {
+	access, viewer := viewerAccess(gctx, i.Member)
+	hidden := access.hidden()
+
+	if err := recordLookup(gctx, i, viewer, user.ID, query, hidden); err != nil {
+		return err
+	}
+
+	resp, err := userInfoData(gctx, i.ID, user, access)
+	if err != nil {
+		return err
+	}
+
+	// Keep the sensitive fields out of the channel, where others could read them
+	if len(hidden) < len(defaultFieldPermissions) {
+		resp.Flags = discordgo.MessageFlagsEphemeral
+	}
+
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: resp,
+	})
+}
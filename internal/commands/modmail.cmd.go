@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/modmail"
+)
+
+// Modmail lets staff manage modmail conversations from their threads
+func Modmail(gctx global.Context, appID string, guildID string) *Command {
+	cmd := DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.ChatApplicationCommand,
+			Name:                     "modmail",
+			Description:              "Manage modmail conversations",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageMessages)),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "close",
+					Description: "Close the conversation of this thread and archive its transcript",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reopen",
+					Description: "Reopen the conversation of this thread",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "block",
+					Description: "Close the conversation of this thread and prevent the user from opening new ones",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "unblock",
+					Description: "Allow a user to open conversations again",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "user",
+							Description: "The user to unblock",
+							Required:    true,
+						},
+					},
+				},
+			},
+		},
+		nil,
+	)
+
+	// thread returns the conversation of the thread the command was used in
+	thread := func(i *discordgo.InteractionCreate) (*modmail.Conversation, error) {
+		return gctx.Inst().Modmail.ByThread(gctx, i.ChannelID)
+	}
+
+	cmd.Middleware = []Middleware{
+		RequirePermissions(discordgo.PermissionManageMessages),
+		func(next CommandHandler) CommandHandler {
+			return func(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+				if gctx.Inst().Modmail == nil {
+					return fmt.Errorf("modmail is disabled")
+				}
+
+				return next(s, i)
+			}
+		},
+	}
+
+	cmd.Subcommands = map[string]CommandHandler{
+		"close": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			conv, err := thread(interaction)
+			if err != nil {
+				return err
+			}
+
+			// Respond before closing, as the thread gets archived
+			if err := respondEphemeral(session, interaction, "Closing the conversation"); err != nil {
+				return err
+			}
+
+			return gctx.Inst().Modmail.Close(gctx, conv, interaction.Member.User)
+		},
+		"reopen": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			conv, err := thread(interaction)
+			if err != nil {
+				return err
+			}
+
+			if err := gctx.Inst().Modmail.Reopen(gctx, conv); err != nil {
+				return err
+			}
+
+			return respondEphemeral(session, interaction, "The conversation was reopened")
+		},
+		"block": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			conv, err := thread(interaction)
+			if err != nil {
+				return err
+			}
+
+			if err := gctx.Inst().Modmail.Block(gctx, conv.UserID, interaction.Member.User); err != nil {
+				return err
+			}
+
+			if err := respondEphemeral(session, interaction, fmt.Sprintf("<@%s> was blocked", conv.UserID)); err != nil {
+				return err
+			}
+
+			if conv.Status != modmail.StatusOpen {
+				return nil
+			}
+
+			return gctx.Inst().Modmail.Close(gctx, conv, interaction.Member.User)
+		},
+		"unblock": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			user := interaction.ApplicationCommandData().Options[0].Options[0].UserValue(session)
+
+			if err := gctx.Inst().Modmail.Unblock(gctx, user.ID); err != nil {
+				return err
+			}
+
+			return respondEphemeral(session, interaction, fmt.Sprintf("<@%s> can open conversations again", user.ID))
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/notes"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// notesPerPage is how many notes are listed per page
+	notesPerPage = 5
+	// noteMaxLength is how long a note can be, so that a page of notes fits in an embed
+	noteMaxLength = 700
+)
+
+// Notes lets staff keep notes about discord members and 7TV users
+func Notes(gctx global.Context, appID string, guildID string) *Command {
+	// subjectOptions identify who notes are about
+	subjectOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "member",
+			Description: "The discord member",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "user",
+			Description: "The username or ID of the 7TV user",
+		},
+	}
+
+	cmd := DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.ChatApplicationCommand,
+			Name:                     "notes",
+			Description:              "Keep notes about users",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageMessages)),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "add",
+					Description: "Write a note about a member or a 7TV user",
+					Options: append([]*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "content",
+							Description: "The note",
+							Required:    true,
+							MaxLength:   noteMaxLength,
+						},
+					}, subjectOptions...),
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "List the notes about a member or a 7TV user",
+					Options:     subjectOptions,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "delete",
+					Description: "Delete a note",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "id",
+							Description: "The ID of the note, as shown in the list",
+							Required:    true,
+						},
+					},
+				},
+			},
+		},
+		nil,
+	)
+
+	cmd.Middleware = []Middleware{
+		RequirePermissions(discordgo.PermissionManageMessages),
+	}
+
+	cmd.Subcommands = map[string]CommandHandler{
+		"add": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			opts := interaction.ApplicationCommandData().Options[0].Options
+
+			subject, label, err := noteSubject(gctx, opts)
+			if err != nil {
+				return err
+			}
+
+			content := ""
+
+			for _, opt := range opts {
+				if opt.Name == "content" {
+					content = strings.TrimSpace(opt.StringValue())
+				}
+			}
+
+			note, err := notes.Add(gctx, gctx, subject, interaction.Member.User.ID, content)
+			if err != nil {
+				return err
+			}
+
+			return respondEphemeral(session, interaction, fmt.Sprintf("Added note `%s` about %s", note.ID.Hex(), label))
+		},
+		"list": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			// Notes are shown in User Info to the same staff only
+			if access, _ := viewerAccess(gctx, interaction.Member); !access[fieldNotes] {
+				return fmt.Errorf("you are not allowed to see notes")
+			}
+
+			subject, label, err := noteSubject(gctx, interaction.ApplicationCommandData().Options[0].Options)
+			if err != nil {
+				return err
+			}
+
+			list, err := notes.List(gctx, gctx, subject)
+			if err != nil {
+				return err
+			}
+
+			if len(list) == 0 {
+				return respondEphemeral(session, interaction, fmt.Sprintf("There are no notes about %s", label))
+			}
+
+			pages := []*discordgo.MessageEmbed{}
+
+			for start := 0; start < len(list); start += notesPerPage {
+				end := start + notesPerPage
+				if end > len(list) {
+					end = len(list)
+				}
+
+				entries := make([]string, 0, end-start)
+				for _, note := range list[start:end] {
+					entries = append(entries, noteEntry(note))
+				}
+
+				pages = append(pages, &discordgo.MessageEmbed{
+					Title:       fmt.Sprintf("Notes (%d)", len(list)),
+					Description: transcript.Truncate(fmt.Sprintf("About %s\n\n%s", label, strings.Join(entries, "\n\n")), 4096),
+				})
+			}
+
+			userID := ""
+			if !subject.UserID.IsZero() {
+				userID = subject.UserID.Hex()
+			}
+
+			return respondPages(gctx, session, interaction, pages, true, subject.DiscordID, userID)
+		},
+		"delete": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			// The deleted note is shown back, so deleting requires seeing notes too
+			if access, _ := viewerAccess(gctx, interaction.Member); !access[fieldNotes] {
+				return fmt.Errorf("you are not allowed to see notes")
+			}
+
+			value := strings.TrimSpace(interaction.ApplicationCommandData().Options[0].Options[0].StringValue())
+
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return fmt.Errorf("%s is not a note ID", value)
+			}
+
+			note, err := notes.Delete(gctx, gctx, id)
+			if errors.Is(err, notes.ErrNotFound) {
+				return fmt.Errorf("there is no note %s", value)
+			} else if err != nil {
+				return err
+			}
+
+			return respondEphemeral(session, interaction, fmt.Sprintf("Deleted note `%s`:\n>>> %s", note.ID.Hex(), note.Content))
+		},
+	}
+
+	return cmd
+}
+
+// noteSubject resolves who notes are about from the member or user option, linking the discord and 7TV accounts.
+// A label to refer to them in responses is also returned
+func noteSubject(gctx global.Context, opts []*discordgo.ApplicationCommandInteractionDataOption) (notes.Subject, string, error) {
+	subject := notes.Subject{}
+
+	for _, opt := range opts {
+		switch opt.Name {
+		case "member":
+			subject.DiscordID = opt.UserValue(nil).ID
+		case "user":
+			value := strings.TrimSpace(opt.StringValue())
+
+			or := bson.A{bson.M{"username": strings.ToLower(value)}}
+			if id, err := primitive.ObjectIDFromHex(value); err == nil {
+				or = append(or, bson.M{"_id": id})
+			}
+
+			user, err := gctx.Inst().Query.Users(gctx, bson.M{"$or": or}).First()
+			if err != nil {
+				return subject, "", fmt.Errorf("no 7TV user matches %s", value)
+			}
+
+			subject.UserID = user.ID
+
+			if con, ind, _ := user.Connections.Discord(); ind != -1 && subject.DiscordID == "" {
+				subject.DiscordID = con.ID
+			}
+		}
+	}
+
+	switch {
+	case subject.DiscordID == "" && subject.UserID.IsZero():
+		return subject, "", fmt.Errorf("a member or a 7TV user is required")
+	case subject.UserID.IsZero():
+		// Notes about a member are also found under their 7TV account
+		user, err := rolesync.LinkedUser(gctx, gctx, subject.DiscordID)
+		if err == nil {
+			subject.UserID = user.ID
+		} else if !errors.Is(err, rolesync.ErrNotLinked) {
+			return subject, "", err
+		}
+	}
+
+	if subject.DiscordID != "" {
+		return subject, fmt.Sprintf("<@%s>", subject.DiscordID), nil
+	}
+
+	return subject, fmt.Sprintf("the 7TV user `%s`", subject.UserID.Hex()), nil
+}
+
+// noteEntry renders a note for a list
+func noteEntry(note notes.Note) string {
+	return fmt.Sprintf("`%s` by <@%s> <t:%d:R>\n%s", note.ID.Hex(), note.AuthorID, note.CreatedAt.Unix(), note.Content)
+}
+
+// userNotes returns the notes about a 7TV user, under their 7TV or discord account
+func userNotes(gctx global.Context, user structures.User) ([]notes.Note, error) {
+	subject := notes.Subject{UserID: user.ID}
+
+	if con, ind, _ := user.Connections.Discord(); ind != -1 {
+		subject.DiscordID = con.ID
+	}
+
+	return notes.List(gctx, gctx, subject)
+}
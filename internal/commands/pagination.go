@@ -0,0 +1,194 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/redis"
+	"github.com/seventv/compactdisc/internal/global"
+)
+
+const (
+	// pagePrefix prefixes the custom ID of pagination buttons
+	pagePrefix = "page"
+	// pageTTL is how long the pages of a response can be browsed for
+	pageTTL = time.Minute * 15
+)
+
+// respondPages responds to an interaction with the first of a set of embeds, and buttons to browse the others.
+// The discord or 7TV IDs of who the embeds are about are recorded, so that purging them also drops the embeds
+func respondPages(gctx global.Context, s *discordgo.Session, i *discordgo.InteractionCreate, pages []*discordgo.MessageEmbed, ephemeral bool, subjects ...string) error {
+	if len(pages) == 0 {
+		return respondEphemeral(s, i, "Nothing to show")
+	}
+
+	data, err := pagesData(gctx, i.ID, pages, subjects...)
+	if err != nil {
+		return err
+	}
+
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+}
+
+// pagesData stores a set of embeds for browsing and returns the response showing the first of them.
+// The embeds are sealed like cached messages, as they show the same kind of data, and indexed by who they are about
+func pagesData(gctx global.Context, id string, pages []*discordgo.MessageEmbed, subjects ...string) (*discordgo.InteractionResponseData, error) {
+	if len(pages) > 1 {
+		j, err := json.Marshal(pages)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := gctx.Inst().Messages.Seal(j, pagesSubject(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := gctx.Inst().Redis.SetEX(gctx, pagesKey(gctx, id), data, pageTTL); err != nil {
+			return nil, err
+		}
+
+		cl := gctx.Inst().Redis.RawClient()
+
+		for _, subject := range subjects {
+			if subject == "" {
+				continue
+			}
+
+			key := pagesAboutKey(gctx, subject).String()
+
+			if err := cl.SAdd(gctx, key, id).Err(); err != nil {
+				return nil, err
+			}
+
+			if err := cl.Expire(gctx, key, pageTTL).Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return pageData(id, pages, 0), nil
+}
+
+// handlePageButton switches the page shown by a paginated response
+func handlePageButton(gctx global.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	parts := strings.Split(i.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid page button")
+	}
+
+	page, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return err
+	}
+
+	data, err := gctx.Inst().Redis.Get(gctx, pagesKey(gctx, parts[1]))
+	if err != nil {
+		return fmt.Errorf("these pages have expired, run the command again")
+	}
+
+	j, err := gctx.Inst().Messages.Open([]byte(data), pagesSubject(parts[1]))
+	if err != nil {
+		return err
+	}
+
+	var pages []*discordgo.MessageEmbed
+	if err := json.Unmarshal(j, &pages); err != nil {
+		return err
+	}
+
+	if page < 0 || page >= len(pages) {
+		return fmt.Errorf("page %d does not exist", page+1)
+	}
+
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: pageData(parts[1], pages, page),
+	})
+}
+
+func pagesKey(gctx global.Context, id string) redis.Key {
+	return gctx.Inst().Redis.ComposeKey("compactdisc", "pages", id)
+}
+
+// pagesAboutKey is the set of the responses about a discord or 7TV account
+func pagesAboutKey(gctx global.Context, subject string) redis.Key {
+	return gctx.Inst().Redis.ComposeKey("compactdisc", "pages", "about", subject)
+}
+
+func pagesSubject(id string) string {
+	return "pages/" + id
+}
+
+func pageData(id string, pages []*discordgo.MessageEmbed, page int) *discordgo.InteractionResponseData {
+	data := &discordgo.InteractionResponseData{
+		Embeds:          []*discordgo.MessageEmbed{pages[page]},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+		Components:      []discordgo.MessageComponent{},
+	}
+
+	buttons := []discordgo.MessageComponent{}
+
+	if len(pages) > 1 {
+		buttons = append(buttons,
+			discordgo.Button{
+				Label:    "Previous",
+				Style:    discordgo.SecondaryButton,
+				Disabled: page == 0,
+				CustomID: fmt.Sprintf("%s:%s:%d", pagePrefix, id, page-1),
+			},
+			discordgo.Button{
+				Label:    fmt.Sprintf("%d / %d", page+1, len(pages)),
+				Style:    discordgo.SecondaryButton,
+				Disabled: true,
+				CustomID: fmt.Sprintf("%s:%s:current", pagePrefix, id),
+			},
+			discordgo.Button{
+				Label:    "Next",
+				Style:    discordgo.SecondaryButton,
+				Disabled: page == len(pages)-1,
+				CustomID: fmt.Sprintf("%s:%s:%d", pagePrefix, id, page+1),
+			},
+		)
+	}
+
+	// Link to what the page is about, such as an emote on the website
+	if pages[page].URL != "" {
+		buttons = append(buttons, discordgo.Button{
+			Label: "Open",
+			Style: discordgo.LinkButton,
+			URL:   pages[page].URL,
+		})
+	}
+
+	if len(buttons) > 0 {
+		data.Components = append(data.Components, discordgo.ActionsRow{
+			Components: buttons,
+		})
+	}
+
+	return data
+}
+
+// respondEphemeral responds to an interaction with a message only visible to the invoker
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+			Flags:           discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/purge"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.uber.org/zap"
+)
+
+// Purge deletes a member's recent messages in every channel, i.e to clean up after a spam raid
+func Purge(gctx global.Context, appID string, guildID string) *Command {
+	cmd := DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.ChatApplicationCommand,
+			Name:                     "purge",
+			Description:              "Delete a member's recent messages in every channel",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageMessages)),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "member",
+					Description: "The member whose messages are deleted",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "minutes",
+					Description: "How far back to delete messages, defaults to 60",
+					MinValue:    utils.PointerOf(1.0),
+					MaxValue:    float64(time.Hour * 24 * 30 / time.Minute),
+				},
+			},
+		},
+		func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			data := interaction.ApplicationCommandData()
+
+			var (
+				member  *discordgo.User
+				minutes int64 = 60
+			)
+
+			for _, opt := range data.Options {
+				switch opt.Name {
+				case "member":
+					member = opt.UserValue(session)
+				case "minutes":
+					minutes = opt.IntValue()
+				}
+			}
+
+			window := time.Duration(minutes) * time.Minute
+
+			result := ""
+
+			msgs, err := purge.Messages(gctx, session, member.ID, time.Now().Add(-window))
+			if err != nil {
+				result = fmt.Sprintf("Failed to purge messages: %s", err.Error())
+			} else {
+				result = fmt.Sprintf("Deleted %d messages from <@%s>", len(msgs), member.ID)
+			}
+
+			if _, err := session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content:         &result,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			}); err != nil {
+				zap.S().Errorw("failed to edit purge response", "error", err)
+			}
+
+			if len(msgs) == 0 {
+				return nil
+			}
+
+			// The interaction was already responded to, so a failure can only be logged
+			if err := sendPurgeLog(gctx, session, interaction, member, window, msgs); err != nil {
+				zap.S().Errorw("failed to send purge log", "error", err)
+			}
+
+			return nil
+		},
+	)
+
+	// Deleting across channels takes a while
+	cmd.Middleware = []Middleware{
+		RequirePermissions(discordgo.PermissionManageMessages),
+		Defer(true),
+	}
+
+	return cmd
+}
+
+// sendPurgeLog posts a transcript of the purged messages to the mod logs
+func sendPurgeLog(gctx global.Context, s *discordgo.Session, i *discordgo.InteractionCreate, member *discordgo.User, window time.Duration, msgs []*discordgo.Message) error {
+	transcript.Sort(msgs)
+
+	title := fmt.Sprintf("Purge of %d messages from %s", len(msgs), member.String())
+	name := fmt.Sprintf("purge-%s-%d", member.ID, time.Now().Unix())
+
+	files, err := transcript.Files(name, title, msgs)
+	if err != nil {
+		return err
+	}
+
+	channels := map[string]int{}
+	for _, msg := range msgs {
+		channels[msg.ChannelID]++
+	}
+
+	moderator := ""
+	if i.Member != nil {
+		moderator = i.Member.User.ID
+	}
+
+	desc := fmt.Sprintf("🧹 **<@%s> purged %d messages from <@%s>** sent in the last %s\n", moderator, len(msgs), member.ID, window)
+	for channelID, n := range channels {
+		desc += fmt.Sprintf("\n<#%s> — %d", channelID, n)
+	}
+
+	_, err = s.ChannelMessageSendComplex(gctx.Config().Discord.Channels["mod_logs"], &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Description: transcript.Truncate(desc, 4096),
+			Color:       0xFF0000,
+			Timestamp:   time.Now().Format(time.RFC3339),
+			Author: &discordgo.MessageEmbedAuthor{
+				Name:    member.String(),
+				IconURL: member.AvatarURL("128"),
+			},
+		}},
+		Files:           files,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+
+	return err
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/messages"
+	"github.com/seventv/compactdisc/internal/transcript"
+)
+
+// recentMessagesPerPage is how many messages are listed on each page
+const recentMessagesPerPage = 10
+
+// RecentMessages lists a member's recent cached messages across every channel
+func RecentMessages(gctx global.Context, appID string, guildID string) *Command {
+	return DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.UserApplicationCommand,
+			Name:                     "Recent Messages",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageMessages)),
+		},
+		func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			data := interaction.ApplicationCommandData()
+			userID := data.TargetID
+
+			msgs, err := gctx.Inst().Messages.ByAuthor(gctx, userID, time.Time{})
+			if err != nil {
+				return err
+			}
+
+			if len(msgs) == 0 {
+				return respondEphemeral(session, interaction, fmt.Sprintf("<@%s> has no cached messages", userID))
+			}
+
+			// Newest first
+			for l, r := 0, len(msgs)-1; l < r; l, r = l+1, r-1 {
+				msgs[l], msgs[r] = msgs[r], msgs[l]
+			}
+
+			author := msgs[0].Author
+			pages := []*discordgo.MessageEmbed{}
+
+			for start := 0; start < len(msgs); start += recentMessagesPerPage {
+				end := start + recentMessagesPerPage
+				if end > len(msgs) {
+					end = len(msgs)
+				}
+
+				desc := ""
+
+				for _, msg := range msgs[start:end] {
+					content := msg.Content
+					if content == "" {
+						content = fmt.Sprintf("*%d attachment(s)*", len(msg.Attachments))
+					}
+
+					desc += fmt.Sprintf("<t:%d:R> in <#%s> [Jump](%s)\n> %s\n", msg.Timestamp.Unix(), msg.ChannelID, messages.JumpURL(msg), transcript.Truncate(content, 200))
+				}
+
+				pages = append(pages, &discordgo.MessageEmbed{
+					Title:       fmt.Sprintf("Recent messages (%d cached)", len(msgs)),
+					Description: transcript.Truncate(desc, 4096),
+					Author: &discordgo.MessageEmbedAuthor{
+						Name:    author.String(),
+						IconURL: author.AvatarURL("128"),
+					},
+				})
+			}
+
+			return respondPages(gctx, session, interaction, pages, true, userID)
+		},
+	)
+}
@@ -0,0 +1,242 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.uber.org/zap"
+)
+
+// commandChanges is the difference between the registered commands of a scope and the defined ones
+type commandChanges struct {
+	// created are defined commands which aren't registered
+	created []*discordgo.ApplicationCommand
+	// updated are defined commands whose registered version differs, keyed by registered ID
+	updated map[string]*discordgo.ApplicationCommand
+	// deleted are registered commands which are no longer defined
+	deleted []*discordgo.ApplicationCommand
+	// unchanged is how many commands are already registered as defined
+	unchanged int
+}
+
+func (c commandChanges) count() int {
+	return len(c.created) + len(c.updated) + len(c.deleted)
+}
+
+// scopes groups commands by the guild they are registered in. Global commands are under an empty guild ID
+func scopes(commands []*Command) map[string][]*discordgo.ApplicationCommand {
+	result := map[string][]*discordgo.ApplicationCommand{}
+
+	for _, cmd := range commands {
+		result[cmd.Data.GuildID] = append(result[cmd.Data.GuildID], cmd.Data)
+	}
+
+	return result
+}
+
+// register brings the registered commands of a scope up to date with the defined ones, only touching those which changed
+func register(gctx global.Context, appID string, guildID string, defined []*discordgo.ApplicationCommand) {
+	disc := gctx.Inst().Discord.Session()
+	z := zap.S().Named("commands").With("guild_id", guildID)
+
+	registered, err := disc.ApplicationCommands(appID, guildID)
+	if err != nil {
+		z.Errorw("failed to fetch registered commands", "error", err)
+		return
+	}
+
+	changes := diffCommands(registered, defined)
+
+	names := func(cmds []*discordgo.ApplicationCommand) []string {
+		s := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			s[i] = cmd.Name
+		}
+
+		sort.Strings(s)
+
+		return s
+	}
+
+	updated := make([]*discordgo.ApplicationCommand, 0, len(changes.updated))
+	for _, cmd := range changes.updated {
+		updated = append(updated, cmd)
+	}
+
+	z = z.With(
+		"created", names(changes.created),
+		"updated", names(updated),
+		"deleted", names(changes.deleted),
+		"unchanged", changes.unchanged,
+	)
+
+	switch changes.count() {
+	case 0:
+		z.Infow("commands are up to date")
+
+		return
+	case 1:
+		// A single change is cheaper to apply on its own
+		for _, cmd := range changes.created {
+			_, err = disc.ApplicationCommandCreate(appID, guildID, cmd)
+		}
+
+		for id, cmd := range changes.updated {
+			_, err = disc.ApplicationCommandEdit(appID, guildID, id, cmd)
+		}
+
+		for _, cmd := range changes.deleted {
+			err = disc.ApplicationCommandDelete(appID, guildID, cmd.ID)
+		}
+	default:
+		// Overwriting keeps the IDs of commands whose name is unchanged, and applies everything at once
+		_, err = disc.ApplicationCommandBulkOverwrite(appID, guildID, defined)
+	}
+
+	if err != nil {
+		z.Errorw("failed to register commands", "error", err)
+		return
+	}
+
+	z.Infow("commands registered")
+}
+
+// diffCommands compares the registered commands of a scope with the defined ones.
+// Commands are identified by their type and name, as names are only unique by type
+func diffCommands(registered []*discordgo.ApplicationCommand, defined []*discordgo.ApplicationCommand) commandChanges {
+	type key struct {
+		typ  discordgo.ApplicationCommandType
+		name string
+	}
+
+	keyOf := func(cmd *discordgo.ApplicationCommand) key {
+		typ := cmd.Type
+		if typ == 0 {
+			typ = discordgo.ChatApplicationCommand
+		}
+
+		return key{typ, cmd.Name}
+	}
+
+	existing := make(map[key]*discordgo.ApplicationCommand, len(registered))
+	for _, cmd := range registered {
+		existing[keyOf(cmd)] = cmd
+	}
+
+	changes := commandChanges{
+		updated: map[string]*discordgo.ApplicationCommand{},
+	}
+
+	for _, cmd := range defined {
+		k := keyOf(cmd)
+
+		reg, ok := existing[k]
+		if !ok {
+			changes.created = append(changes.created, cmd)
+			continue
+		}
+
+		delete(existing, k)
+
+		if sameCommand(reg, cmd) {
+			changes.unchanged++
+		} else {
+			changes.updated[reg.ID] = cmd
+		}
+	}
+
+	for _, cmd := range existing {
+		changes.deleted = append(changes.deleted, cmd)
+	}
+
+	return changes
+}
+
+// commandSpec holds the fields of a command which are defined by the bot, normalized so that they can be compared
+type commandSpec struct {
+	Type                     discordgo.ApplicationCommandType      `json:"type"`
+	Name                     string                                `json:"name"`
+	NameLocalizations        map[discordgo.Locale]string           `json:"name_localizations,omitempty"`
+	Description              string                                `json:"description,omitempty"`
+	DescriptionLocalizations map[discordgo.Locale]string           `json:"description_localizations,omitempty"`
+	DefaultMemberPermissions *int64                                `json:"default_member_permissions,omitempty"`
+	DMPermission             bool                                  `json:"dm_permission"`
+	Options                  []*discordgo.ApplicationCommandOption `json:"options,omitempty"`
+}
+
+// sameCommand returns whether a registered command matches its definition
+func sameCommand(registered *discordgo.ApplicationCommand, defined *discordgo.ApplicationCommand) bool {
+	a, err := json.Marshal(specOf(registered))
+	if err != nil {
+		return false
+	}
+
+	b, err := json.Marshal(specOf(defined))
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(a, b)
+}
+
+func specOf(cmd *discordgo.ApplicationCommand) commandSpec {
+	spec := commandSpec{
+		Type:                     cmd.Type,
+		Name:                     cmd.Name,
+		Description:              cmd.Description,
+		DefaultMemberPermissions: cmd.DefaultMemberPermissions,
+		DMPermission:             cmd.DMPermission == nil || *cmd.DMPermission, // discord defaults to allowing DMs
+		Options:                  normalizeOptions(cmd.Options),
+	}
+
+	if spec.Type == 0 {
+		spec.Type = discordgo.ChatApplicationCommand
+	}
+
+	if cmd.NameLocalizations != nil && len(*cmd.NameLocalizations) > 0 {
+		spec.NameLocalizations = *cmd.NameLocalizations
+	}
+
+	if cmd.DescriptionLocalizations != nil && len(*cmd.DescriptionLocalizations) > 0 {
+		spec.DescriptionLocalizations = *cmd.DescriptionLocalizations
+	}
+
+	return spec
+}
+
+// normalizeOptions copies options, replacing empty collections with nil as discord omits them
+func normalizeOptions(opts []*discordgo.ApplicationCommandOption) []*discordgo.ApplicationCommandOption {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	result := make([]*discordgo.ApplicationCommandOption, len(opts))
+
+	for i, opt := range opts {
+		o := *opt
+		o.Options = normalizeOptions(opt.Options)
+
+		if len(o.ChannelTypes) == 0 {
+			o.ChannelTypes = nil
+		}
+
+		if len(o.Choices) == 0 {
+			o.Choices = nil
+		}
+
+		if len(o.NameLocalizations) == 0 {
+			o.NameLocalizations = nil
+		}
+
+		if len(o.DescriptionLocalizations) == 0 {
+			o.DescriptionLocalizations = nil
+		}
+
+		result[i] = &o
+	}
+
+	return result
+}
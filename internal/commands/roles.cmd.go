@@ -0,0 +1,235 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// roleAutocompleteLimit is how many choices discord accepts for autocompletion
+const roleAutocompleteLimit = 25
+
+// Roles lets staff inspect and provision the guild's roles linked to 7TV roles
+func Roles(gctx global.Context, appID string, guildID string) *Command {
+	cmd := DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.ChatApplicationCommand,
+			Name:                     "roles",
+			Description:              "Manage the roles synced from 7TV",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageRoles)),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "diagnose",
+					Description: "Check whether every linked role can be synced and how many members are out of sync",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "provision",
+					Description: "Create and link a discord role for a 7TV role",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "role",
+							Description:  "The 7TV role to create a discord role for",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "style",
+					Description: "Update the name, color and position of the linked roles to match 7TV",
+				},
+			},
+		},
+		nil,
+	)
+
+	cmd.Middleware = []Middleware{
+		RequirePermissions(discordgo.PermissionManageRoles),
+		Defer(true),
+	}
+
+	cmd.Subcommands = map[string]CommandHandler{
+		"diagnose": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			report, err := rolesync.Diagnose(gctx, gctx)
+			if err != nil {
+				return err
+			}
+
+			b, err := rolesync.ReportCSV(report)
+			if err != nil {
+				return err
+			}
+
+			_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Embeds: &[]*discordgo.MessageEmbed{diagnosisEmbed(report)},
+				Files: []*discordgo.File{{
+					Name:        "roles.csv",
+					ContentType: "text/csv",
+					Reader:      bytes.NewReader(b),
+				}},
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+
+			return err
+		},
+		"provision": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			value := interaction.ApplicationCommandData().Options[0].Options[0].StringValue()
+
+			roleID, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return fmt.Errorf("%s is not a 7TV role, pick one of the suggestions", value)
+			}
+
+			report, err := rolesync.Provision(gctx, gctx, []primitive.ObjectID{roleID})
+			if errors.Is(err, rolesync.ErrUnknownRole) {
+				return fmt.Errorf("%s is not a 7TV role, pick one of the suggestions", value)
+			} else if err != nil {
+				return err
+			}
+
+			result := ""
+
+			switch {
+			case len(report.Created) > 0:
+				result = fmt.Sprintf("Created <@&%s> for the 7TV role **%s**", report.Created[0].DiscordID, report.Created[0].Name)
+			case len(report.Linked) > 0:
+				result = fmt.Sprintf("Nothing changed: the 7TV role **%s** is already linked to <@&%s>", report.Linked[0].Name, report.Linked[0].DiscordID)
+			}
+
+			result += styleResult(report.Styled)
+
+			_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content:         &result,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+
+			return err
+		},
+		"style": func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			styled, err := rolesync.Style(gctx, gctx)
+			if err != nil {
+				return err
+			}
+
+			result := "Nothing changed: the linked roles already match 7TV"
+			if len(styled) > 0 {
+				result = strings.TrimPrefix(styleResult(styled), "\n")
+			}
+
+			_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content:         &result,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+
+			return err
+		},
+	}
+
+	// Suggests the 7TV roles which aren't linked to an existing discord role
+	cmd.Autocomplete = func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+		value := strings.ToLower(interaction.ApplicationCommandData().Options[0].Options[0].StringValue())
+
+		appRoles, err := gctx.Inst().Roles.AppRoles(gctx)
+		if err != nil {
+			return err
+		}
+
+		guildRoles, err := gctx.Inst().Roles.GuildRoles(guildID)
+		if err != nil {
+			return err
+		}
+
+		choices := []*discordgo.ApplicationCommandOptionChoice{}
+
+		for _, rol := range appRoles {
+			if len(choices) == roleAutocompleteLimit {
+				break
+			}
+
+			if _, ok := guildRoles[strconv.FormatUint(rol.DiscordID, 10)]; ok {
+				continue // already linked
+			}
+
+			if !strings.Contains(strings.ToLower(rol.Name), value) {
+				continue
+			}
+
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  rol.Name,
+				Value: rol.ID.Hex(),
+			})
+		}
+
+		return session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+	}
+
+	return cmd
+}
+
+// styleResult lists the roles restyled to match 7TV
+func styleResult(styled []string) string {
+	if len(styled) == 0 {
+		return ""
+	}
+
+	return "\n**Restyled to match 7TV:** " + strings.Join(styled, ", ")
+}
+
+// diagnosisEmbed summarizes a role report, the full report being attached as CSV
+func diagnosisEmbed(report compactdisc.RoleReport) *discordgo.MessageEmbed {
+	sb := strings.Builder{}
+
+	for _, entry := range report.Roles {
+		line := ""
+
+		switch {
+		case !entry.Exists:
+			line = fmt.Sprintf("❌ **%s** (`%s`): %s", entry.Name, entry.DiscordID, entry.Reason)
+		case !entry.Manageable:
+			line = fmt.Sprintf("⚠️ **%s** <@&%s>: %s", entry.Name, entry.DiscordID, entry.Reason)
+		default:
+			line = fmt.Sprintf("✅ **%s** <@&%s>", entry.Name, entry.DiscordID)
+		}
+
+		if entry.Missing > 0 || entry.Extra > 0 {
+			line += fmt.Sprintf(" — %d missing, %d extra", entry.Missing, entry.Extra)
+		}
+
+		sb.WriteString(line + "\n")
+	}
+
+	if len(report.Roles) == 0 {
+		sb.WriteString("No 7TV role is linked to a discord role")
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Role Diagnostics",
+		Description: transcript.Truncate(sb.String(), 4096),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Based on %d members with a linked 7TV account", report.Members),
+		},
+		Timestamp: report.GeneratedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,277 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// Middleware wraps the handler of an interaction, i.e to check permissions before it runs
+type Middleware func(next CommandHandler) CommandHandler
+
+type componentRoute struct {
+	prefix  string
+	handler CommandHandler
+}
+
+// Router dispatches interactions to the handler registered for their command, subcommand or custom ID
+type Router struct {
+	middleware   []Middleware
+	commands     map[string]CommandHandler
+	autocomplete map[string]CommandHandler
+	components   []componentRoute
+	modals       []componentRoute
+}
+
+func NewRouter() *Router {
+	return &Router{
+		commands:     map[string]CommandHandler{},
+		autocomplete: map[string]CommandHandler{},
+	}
+}
+
+// Use adds middleware which runs for every interaction, in the order it was added
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Command routes a command, or one of its subcommands when a subcommand path such as "modmail close" is given
+func (r *Router) Command(path string, handler CommandHandler, mw ...Middleware) {
+	r.commands[path] = chain(handler, mw)
+}
+
+// Autocomplete routes the autocompletion of a command's options
+func (r *Router) Autocomplete(name string, handler CommandHandler) {
+	r.autocomplete[name] = handler
+}
+
+// Component routes the buttons and select menus whose custom ID starts with a prefix
+func (r *Router) Component(prefix string, handler CommandHandler, mw ...Middleware) {
+	r.components = append(r.components, componentRoute{prefix, chain(handler, mw)})
+}
+
+// Modal routes the submitted modals whose custom ID starts with a prefix
+func (r *Router) Modal(prefix string, handler CommandHandler, mw ...Middleware) {
+	r.modals = append(r.modals, componentRoute{prefix, chain(handler, mw)})
+}
+
+// Add routes a defined command along with its subcommands and autocompletion
+func (r *Router) Add(cmd *Command) {
+	name := cmd.Data.Name
+
+	if cmd.Handler != nil {
+		r.Command(name, cmd.Handler, cmd.Middleware...)
+	}
+
+	for sub, handler := range cmd.Subcommands {
+		r.Command(name+" "+sub, handler, cmd.Middleware...)
+	}
+
+	if cmd.Autocomplete != nil {
+		r.Autocomplete(name, cmd.Autocomplete)
+	}
+}
+
+// Handle dispatches an interaction. Errors are reported to the user who triggered it
+func (r *Router) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	handler, route := r.match(i)
+	if handler == nil {
+		return
+	}
+
+	if err := chain(handler, r.middleware)(s, i); err != nil {
+		zap.S().Errorw("failed to handle interaction", "route", route, "error", err)
+
+		if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+			return // there is nobody to tell
+		}
+
+		respondError(s, i, err)
+	}
+}
+
+// match returns the handler of an interaction and the route it was found under
+func (r *Router) match(i *discordgo.InteractionCreate) (CommandHandler, string) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		path := commandPath(data)
+
+		// Fall back to the command's own handler for subcommands which aren't routed separately
+		for {
+			if h, ok := r.commands[path]; ok {
+				return h, path
+			}
+
+			idx := strings.LastIndex(path, " ")
+			if idx < 0 {
+				break
+			}
+
+			path = path[:idx]
+		}
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		name := i.ApplicationCommandData().Name
+
+		return r.autocomplete[name], name
+	case discordgo.InteractionMessageComponent:
+		return matchPrefix(r.components, i.MessageComponentData().CustomID)
+	case discordgo.InteractionModalSubmit:
+		return matchPrefix(r.modals, i.ModalSubmitData().CustomID)
+	}
+
+	return nil, ""
+}
+
+// commandPath returns the name of a command followed by its subcommand group and subcommand, if any
+func commandPath(data discordgo.ApplicationCommandInteractionData) string {
+	path := data.Name
+	opts := data.Options
+
+	for len(opts) > 0 && (opts[0].Type == discordgo.ApplicationCommandOptionSubCommandGroup || opts[0].Type == discordgo.ApplicationCommandOptionSubCommand) {
+		path += " " + opts[0].Name
+		opts = opts[0].Options
+	}
+
+	return path
+}
+
+func matchPrefix(routes []componentRoute, customID string) (CommandHandler, string) {
+	for _, route := range routes {
+		if strings.HasPrefix(customID, route.prefix) {
+			return route.handler, route.prefix
+		}
+	}
+
+	return nil, ""
+}
+
+func chain(handler CommandHandler, mw []Middleware) CommandHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](handler)
+	}
+
+	return handler
+}
+
+// deferredError is an error of a handler whose interaction was deferred, so the deferred response is still waiting for it
+type deferredError struct {
+	err error
+}
+
+func (e deferredError) Error() string {
+	return e.err.Error()
+}
+
+func (e deferredError) Unwrap() error {
+	return e.err
+}
+
+// respondError tells the user an interaction failed, in place of the deferred response or following up if it was already responded to
+func respondError(s *discordgo.Session, i *discordgo.InteractionCreate, err error) {
+	msg := err.Error()
+
+	var deferred deferredError
+	if errors.As(err, &deferred) {
+		if _, rerr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:         &msg,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}); rerr != nil {
+			zap.S().Errorw("failed to respond to interaction about the failure to handle it", "error", rerr)
+		}
+
+		return
+	}
+
+	if rerr := respondEphemeral(s, i, msg); rerr == nil {
+		return
+	}
+
+	if _, rerr := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content:         msg,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+		Flags:           discordgo.MessageFlagsEphemeral,
+	}); rerr != nil {
+		zap.S().Errorw("failed to respond to interaction about the failure to handle it", "error", rerr)
+	}
+}
+
+// Recover turns a panic in a handler into an error, so that the user is told about it
+func Recover() Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(s *discordgo.Session, i *discordgo.InteractionCreate) (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					err = panicError(v)
+				}
+			}()
+
+			return next(s, i)
+		}
+	}
+}
+
+// panicError logs a panic in a handler and returns the error to tell the user about it
+func panicError(v interface{}) error {
+	zap.S().Errorw("panic in interaction handler", "panic", v, "stack", string(debug.Stack()))
+
+	return fmt.Errorf("something went wrong")
+}
+
+// RequirePermissions rejects interactions from members lacking permissions,
+// as the default permissions of a command can be overridden by the guild
+func RequirePermissions(perms int64) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+			if i.Member == nil || i.Member.Permissions&perms != perms {
+				return fmt.Errorf("you are not allowed to do this")
+			}
+
+			return next(s, i)
+		}
+	}
+}
+
+// Defer acknowledges an interaction before its handler runs, for handlers which take longer than discord waits for.
+// The handler must then edit the response instead of responding
+func Defer(ephemeral bool) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(s *discordgo.Session, i *discordgo.InteractionCreate) (err error) {
+			data := &discordgo.InteractionResponseData{}
+			if ephemeral {
+				data.Flags = discordgo.MessageFlagsEphemeral
+			}
+
+			typ := discordgo.InteractionResponseDeferredChannelMessageWithSource
+			if i.Type == discordgo.InteractionMessageComponent {
+				typ = discordgo.InteractionResponseDeferredMessageUpdate
+			}
+
+			if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: typ,
+				Data: data,
+			}); err != nil {
+				return err
+			}
+
+			// A deferred component update has no response to fill in, so its errors are followed up on instead
+			if typ == discordgo.InteractionResponseDeferredChannelMessageWithSource {
+				defer func() {
+					if v := recover(); v != nil {
+						err = panicError(v)
+					}
+
+					if err != nil {
+						err = deferredError{err}
+					}
+				}()
+			}
+
+			return next(s, i)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/rolesync"
+)
+
+// SyncRoles syncs a member's discord roles with their 7TV roles, as the SYNC_USER operation does
+func SyncRoles(gctx global.Context, appID string, guildID string) *Command {
+	cmd := DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.UserApplicationCommand,
+			Name:                     "Sync Roles",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageRoles)),
+		},
+		func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			targetID := interaction.ApplicationCommandData().TargetID
+
+			result := ""
+
+			user, err := rolesync.LinkedUser(gctx, gctx, targetID)
+			if err == nil {
+				var diff rolesync.RoleDiff
+
+				diff, err = rolesync.Sync(gctx, gctx, user, false)
+				if err == nil {
+					result = syncResult(targetID, diff)
+				}
+			}
+
+			switch {
+			case errors.Is(err, rolesync.ErrNotLinked):
+				result = fmt.Sprintf("Nothing changed: <@%s> has no linked 7TV account", targetID)
+			case errors.Is(err, rolesync.ErrNotMember):
+				result = fmt.Sprintf("Nothing changed: <@%s> is not in the server", targetID)
+			case err != nil:
+				return err
+			}
+
+			_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content:         &result,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+
+			return err
+		},
+	)
+
+	cmd.Middleware = []Middleware{
+		RequirePermissions(discordgo.PermissionManageRoles),
+		Defer(true),
+	}
+
+	return cmd
+}
+
+// syncResult describes the roles changed by a sync
+func syncResult(userID string, diff rolesync.RoleDiff) string {
+	sb := strings.Builder{}
+
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+		sb.WriteString(fmt.Sprintf("Nothing changed: the roles of <@%s> are already in sync", userID))
+	} else {
+		sb.WriteString(fmt.Sprintf("Synced the roles of <@%s>", userID))
+
+		if len(diff.Added) > 0 {
+			sb.WriteString("\n**Added:** " + strings.Join(diff.Added, ", "))
+		}
+
+		if len(diff.Removed) > 0 {
+			sb.WriteString("\n**Removed:** " + strings.Join(diff.Removed, ", "))
+		}
+	}
+
+	if len(diff.Skipped) > 0 {
+		sb.WriteString("\n**Skipped, as they are above the bot's highest role or managed by an integration:** " + strings.Join(diff.Skipped, ", "))
+	}
+
+	return sb.String()
+}
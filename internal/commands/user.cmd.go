@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userAutocompleteLimit is how many choices discord accepts for autocompletion
+const userAutocompleteLimit = 25
+
+// User retrieves information about a 7TV user by their username, ID or the ID of one of their connections
+func User(gctx global.Context, appID string, guildID string) *Command {
+	cmd := DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.ChatApplicationCommand,
+			Name:                     "user",
+			Description:              "Look up a 7TV user",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageRoles)),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "user",
+					Description:  "A 7TV username or ID, or a Twitch or YouTube account ID",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			value := strings.TrimSpace(interaction.ApplicationCommandData().Options[0].StringValue())
+
+			or := bson.A{
+				bson.M{"username": strings.ToLower(value)},
+				bson.M{"connections": bson.M{"$elemMatch": bson.M{
+					"platform": bson.M{"$in": bson.A{
+						structures.UserConnectionPlatformTwitch,
+						structures.UserConnectionPlatformYouTube,
+					}},
+					"id": value,
+				}}},
+			}
+
+			if id, err := primitive.ObjectIDFromHex(value); err == nil {
+				or = append(or, bson.M{"_id": id})
+			}
+
+			user, err := gctx.Inst().Query.Users(gctx, bson.M{"$or": or}).First()
+			if err != nil {
+				if rerr := recordLookup(gctx, interaction, structures.User{}, primitive.NilObjectID, value, nil); rerr != nil {
+					return rerr
+				}
+
+				return respondEphemeral(session, interaction, fmt.Sprintf("No 7TV user matches %s", value))
+			}
+
+			return respondUserInfo(gctx, session, interaction, user, value)
+		},
+	)
+
+	cmd.Autocomplete = func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+		value := strings.ToLower(strings.TrimSpace(interaction.ApplicationCommandData().Options[0].StringValue()))
+
+		choices := []*discordgo.ApplicationCommandOptionChoice{}
+
+		if value != "" {
+			cur, err := gctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).Find(gctx, bson.M{
+				"username": bson.M{"$regex": "^" + regexp.QuoteMeta(value)},
+			}, options.Find().
+				SetLimit(userAutocompleteLimit).
+				SetProjection(bson.M{"username": 1, "display_name": 1}),
+			)
+			if err != nil {
+				return err
+			}
+
+			users := []structures.User{}
+			if err := cur.All(gctx, &users); err != nil {
+				return err
+			}
+
+			for _, u := range users {
+				choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+					Name:  fmt.Sprintf("%s (%s)", u.DisplayName, u.Username),
+					Value: u.Username,
+				})
+			}
+		}
+
+		return session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+	}
+
+	return cmd
+}
@@ -6,18 +6,21 @@ import (
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/mongo"
 	"github.com/seventv/common/structures/v3"
 	"github.com/seventv/common/utils"
 	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/transcript"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
 )
 
 // UserInfo retrieves information about a 7TV user via their Discord connection
 func UserInfo(gctx global.Context, appID string, guildID string) *Command {
 	return DefineCommand(
 		&discordgo.ApplicationCommand{
-			ID:                       appID,
 			ApplicationID:            appID,
 			GuildID:                  guildID,
 			Type:                     discordgo.UserApplicationCommand,
@@ -37,102 +40,414 @@ func UserInfo(gctx global.Context, appID string, guildID string) *Command {
 				}},
 			}).First()
 			if err != nil {
+				if rerr := recordLookup(gctx, interaction, structures.User{}, primitive.NilObjectID, userID, nil); rerr != nil {
+					return rerr
+				}
+
 				return err
 			}
 
-			avatarURL := ""
-			if user.AvatarID != "" {
-				avatarURL = fmt.Sprintf("https://%s/pp/%s/%s", gctx.Config().CdnURL, user.ID.Hex(), user.AvatarID)
-			} else {
-				for _, con := range user.Connections {
-					if con.Platform == structures.UserConnectionPlatformTwitch {
-						if con, err := structures.ConvertUserConnection[structures.UserConnectionDataTwitch](con); err == nil {
-							avatarURL = con.Data.ProfileImageURL
-						}
-					} else if con.Platform == structures.UserConnectionPlatformDiscord {
-						if con, err := structures.ConvertUserConnection[structures.UserConnectionDataDiscord](con); err == nil {
-							avatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", con.Data.ID, con.Data.Avatar)
-						}
-					}
+			return respondUserInfo(gctx, session, interaction, user, userID)
+		},
+	)
+}
+
+const (
+	// userInfoPreview is how many items of a list are shown on the first page, the full list going on the next pages
+	userInfoPreview = 5
+	// userInfoPageLength is how many characters of a list go on a page, well within the limit of an embed's description
+	userInfoPageLength = 2048
+)
+
+// cosmeticRef is the part of a badge or paint entitlement needed to list it
+type cosmeticRef struct {
+	Kind string `bson:"kind"`
+	Data struct {
+		RefID    primitive.ObjectID `bson:"ref"`
+		Selected bool               `bson:"selected"`
+	} `bson:"data"`
+	// Condition restricts the entitlement to users with some roles
+	Condition struct {
+		AnyRoles []primitive.ObjectID `bson:"any_roles"`
+		AllRoles []primitive.ObjectID `bson:"all_roles"`
+	} `bson:"condition"`
+	Disabled bool `bson:"disabled"`
+}
+
+// applies returns whether an entitlement is in effect for a user with the given roles
+func (ref cosmeticRef) applies(roleIDs map[primitive.ObjectID]struct{}) bool {
+	if ref.Disabled {
+		return false
+	}
+
+	for _, id := range ref.Condition.AllRoles {
+		if _, ok := roleIDs[id]; !ok {
+			return false
+		}
+	}
+
+	if len(ref.Condition.AnyRoles) == 0 {
+		return true
+	}
+
+	for _, id := range ref.Condition.AnyRoles {
+		if _, ok := roleIDs[id]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cosmetic is the part of a badge or paint needed to list it
+type cosmetic struct {
+	ID   primitive.ObjectID `bson:"_id"`
+	Kind string             `bson:"kind"`
+	Name string             `bson:"name"`
+}
+
+// userInfoData renders the information about a 7TV user. The first page is an overview, and the lists too long for it follow.
+// Sensitive fields are redacted unless the viewer has access to them, and sections which fail to load are marked as unavailable
+func userInfoData(gctx global.Context, id string, user structures.User, access fieldAccess) (*discordgo.InteractionResponseData, error) {
+	z := zap.S().Named("user_info").With("user_id", user.ID.Hex())
+
+	avatarURL := ""
+	if user.AvatarID != "" {
+		avatarURL = fmt.Sprintf("https://%s/pp/%s/%s", gctx.Config().CdnURL, user.ID.Hex(), user.AvatarID)
+	} else {
+		for _, con := range user.Connections {
+			if con.Platform == structures.UserConnectionPlatformTwitch {
+				if con, err := structures.ConvertUserConnection[structures.UserConnectionDataTwitch](con); err == nil {
+					avatarURL = con.Data.ProfileImageURL
+				}
+			} else if con.Platform == structures.UserConnectionPlatformDiscord {
+				if con, err := structures.ConvertUserConnection[structures.UserConnectionDataDiscord](con); err == nil {
+					avatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", con.Data.ID, con.Data.Avatar)
 				}
 			}
+		}
+	}
 
-			// Format an embed
-			embed := &discordgo.MessageEmbed{
-				Type:        discordgo.EmbedTypeRich,
-				Title:       fmt.Sprintf("%s (%s)", user.DisplayName, user.Username),
-				URL:         user.WebURL(gctx.Config().WebsiteURL),
-				Description: user.Biography,
-				Author: &discordgo.MessageEmbedAuthor{
-					URL:     user.WebURL(gctx.Config().WebsiteURL),
-					Name:    user.DisplayName,
-					IconURL: avatarURL,
-				},
-				Timestamp: user.ID.Timestamp().Format(time.RFC3339),
-				Color:     int(user.GetHighestRole().Color),
-				Fields: func() []*discordgo.MessageEmbedField {
-					fields := make([]*discordgo.MessageEmbedField, 0)
-
-					// Add the user's connections
-					for _, con := range user.Connections {
-						fields = append(fields, &discordgo.MessageEmbedField{
-							Name:   string(con.Platform),
-							Value:  con.ID,
-							Inline: true,
-						})
-					}
-
-					// Add the user's roles
-					fields = append(fields, &discordgo.MessageEmbedField{
-						Name: "Roles",
-						Value: func() string {
-							roleList := make([]string, len(user.Roles))
-							for i, role := range user.Roles {
-								roleList[i] = role.Name
-							}
-
-							return strings.Join(roleList, "\n")
-						}(),
-						Inline: false,
-					})
-
-					editorIDs := make([]primitive.ObjectID, len(user.Editors))
-					for i, editor := range user.Editors {
-						editorIDs[i] = editor.ID
-					}
-
-					editors, err := gctx.Inst().Query.Users(gctx, bson.M{"_id": bson.M{"$in": editorIDs}}).Items()
-
-					if err == nil {
-						fields = append(fields, &discordgo.MessageEmbedField{
-							Name: "Editors",
-							Value: func() string {
-								editorList := make([]string, len(user.Editors))
-								for i, u := range editors {
-									editorList[i] = fmt.Sprintf("[%s (%s)](%s)", u.DisplayName, u.Username, u.WebURL(gctx.Config().WebsiteURL))
-								}
-
-								if len(editorList) == 0 {
-									return "None"
-								}
-
-								return strings.Join(editorList, "\n")
-							}(),
-							Inline: false,
-						})
-					}
-
-					return fields
-				}(),
-			}
+	webURL := user.WebURL(gctx.Config().WebsiteURL)
 
-			return session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: fmt.Sprintf("**[user]** [%s (%s)](%s)", user.DisplayName, user.Username, user.WebURL(gctx.Config().WebsiteURL)),
-					Embeds:  []*discordgo.MessageEmbed{embed},
-				},
-			})
+	// page returns an embed about the user, for the overview and the lists
+	page := func(title string) *discordgo.MessageEmbed {
+		return &discordgo.MessageEmbed{
+			Type:  discordgo.EmbedTypeRich,
+			Title: title,
+			URL:   webURL,
+			Author: &discordgo.MessageEmbedAuthor{
+				URL:     webURL,
+				Name:    user.DisplayName,
+				IconURL: avatarURL,
+			},
+			Timestamp: user.ID.Timestamp().Format(time.RFC3339),
+			Color:     int(user.GetHighestRole().Color),
+		}
+	}
+
+	// Format an embed
+	embed := page(fmt.Sprintf("%s (%s)", user.DisplayName, user.Username))
+	embed.Description = transcript.Truncate(user.Biography, 1024)
+	embed.Fields = make([]*discordgo.MessageEmbedField, 0)
+
+	// Add the user's connections
+	for _, con := range user.Connections {
+		value := con.ID
+		if !access[fieldConnections] {
+			value = "*Hidden*"
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   string(con.Platform),
+			Value:  value,
+			Inline: true,
+		})
+	}
+
+	lastActive := "Never"
+	if !user.State.LastActive.IsZero() {
+		lastActive = fmt.Sprintf("<t:%d:R>", user.State.LastActive.Unix())
+	}
+
+	embed.Fields = append(embed.Fields,
+		&discordgo.MessageEmbedField{
+			Name:   "Created",
+			Value:  fmt.Sprintf("<t:%d:D>", user.ID.Timestamp().Unix()),
+			Inline: true,
+		},
+		&discordgo.MessageEmbedField{
+			Name:   "Last Active",
+			Value:  lastActive,
+			Inline: true,
 		},
 	)
+
+	// The lists shown in full on the following pages
+	pages := []*discordgo.MessageEmbed{embed}
+	lists := []struct {
+		name  string
+		items []string
+	}{}
+
+	// list adds a field previewing a list, and queues the full list for the following pages if it doesn't fit
+	list := func(name string, items []string) {
+		value := "None"
+		if len(items) > 0 {
+			value = strings.Join(items, "\n")
+		}
+
+		if len(items) > userInfoPreview {
+			value = fmt.Sprintf("%s\n*and %d more on the next pages*", strings.Join(items[:userInfoPreview], "\n"), len(items)-userInfoPreview)
+
+			lists = append(lists, struct {
+				name  string
+				items []string
+			}{name, items})
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  name,
+			Value: transcript.Truncate(value, 1024),
+		})
+	}
+
+	// Add the user's roles
+	roleList := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		roleList[i] = role.Name
+	}
+
+	list("Roles", roleList)
+
+	// unavailable adds a field for a section which failed to load, so that the rest is still shown
+	unavailable := func(name string, err error) {
+		z.Errorw("failed to load user info section", "section", name, "error", err)
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  name,
+			Value: "*Unavailable*",
+		})
+	}
+
+	// Add the user's active emote sets, which are set per connection
+	setIDs := []primitive.ObjectID{}
+	for _, con := range user.Connections {
+		if !con.EmoteSetID.IsZero() && !utils.Contains(setIDs, con.EmoteSetID) {
+			setIDs = append(setIDs, con.EmoteSetID)
+		}
+	}
+
+	if len(setIDs) > 0 {
+		if sets, err := gctx.Inst().Query.EmoteSets(gctx, bson.M{"_id": bson.M{"$in": setIDs}}).Items(); err != nil {
+			unavailable("Active Emote Sets", err)
+		} else {
+			setList := make([]string, len(sets))
+			for i, set := range sets {
+				setList[i] = fmt.Sprintf("[%s](%s/emote-sets/%s) (%d / %d emotes)", set.Name, gctx.Config().WebsiteURL, set.ID.Hex(), len(set.Emotes), set.Capacity)
+			}
+
+			list("Active Emote Sets", setList)
+		}
+	}
+
+	// Add the user's badges and paints
+	if cosmetics, err := userCosmetics(gctx, user); err != nil {
+		unavailable("Badges", err)
+		unavailable("Paints", err)
+	} else {
+		list("Badges", cosmetics["BADGE"])
+		list("Paints", cosmetics["PAINT"])
+	}
+
+	// Add the user's active bans
+	if bans, err := userBans(gctx, user.ID); err != nil {
+		unavailable("Active Bans", err)
+	} else {
+		list("Active Bans", banListOf(bans, access))
+	}
+
+	// Add the notes staff keep about the user
+	if !access[fieldNotes] {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Notes",
+			Value: "*Hidden*",
+		})
+	} else if noted, err := userNotes(gctx, user); err != nil {
+		unavailable("Notes", err)
+	} else {
+		noteList := make([]string, len(noted))
+		for i, note := range noted {
+			noteList[i] = noteEntry(note)
+		}
+
+		list("Notes", noteList)
+	}
+
+	editorIDs := make([]primitive.ObjectID, len(user.Editors))
+	for i, editor := range user.Editors {
+		editorIDs[i] = editor.ID
+	}
+
+	switch {
+	case !access[fieldEditors]:
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Editors",
+			Value: fmt.Sprintf("*%d hidden*", len(user.Editors)),
+		})
+	case len(editorIDs) == 0:
+		list("Editors", nil)
+	default:
+		if editors, err := gctx.Inst().Query.Users(gctx, bson.M{"_id": bson.M{"$in": editorIDs}}).Items(); err != nil {
+			unavailable("Editors", err)
+		} else {
+			editorList := make([]string, len(editors))
+			for i, u := range editors {
+				editorList[i] = fmt.Sprintf("[%s (%s)](%s)", u.DisplayName, u.Username, u.WebURL(gctx.Config().WebsiteURL))
+			}
+
+			list("Editors", editorList)
+		}
+	}
+
+	// Split the full lists into pages
+	for _, l := range lists {
+		sb := strings.Builder{}
+
+		flush := func() {
+			p := page(fmt.Sprintf("%s of %s", l.name, user.DisplayName))
+			p.Description = sb.String()
+
+			pages = append(pages, p)
+			sb.Reset()
+		}
+
+		for _, item := range l.items {
+			item = transcript.Truncate(item, userInfoPageLength)
+
+			if sb.Len() > 0 && sb.Len()+len(item)+1 > userInfoPageLength {
+				flush()
+			}
+
+			sb.WriteString(item + "\n")
+		}
+
+		flush()
+	}
+
+	discordID := ""
+	if con, ind, _ := user.Connections.Discord(); ind != -1 {
+		discordID = con.ID
+	}
+
+	data, err := pagesData(gctx, id, pages, user.ID.Hex(), discordID)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Content = fmt.Sprintf("**[user]** [%s (%s)](%s)", user.DisplayName, user.Username, webURL)
+
+	return data, nil
+}
+
+// userBans returns the bans of a user which haven't expired
+func userBans(gctx global.Context, userID primitive.ObjectID) ([]structures.Ban, error) {
+	cur, err := gctx.Inst().Mongo.Collection(mongo.CollectionNameBans).Find(gctx, bson.M{
+		"victim_id": userID,
+		"expire_at": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bans := []structures.Ban{}
+	if err := cur.All(gctx, &bans); err != nil {
+		return nil, err
+	}
+
+	return bans, nil
+}
+
+// banListOf renders bans for a list, hiding their reasons unless the viewer has access to them
+func banListOf(bans []structures.Ban, access fieldAccess) []string {
+	banList := make([]string, len(bans))
+	for i, ban := range bans {
+		expiry := fmt.Sprintf("<t:%d:R>", ban.ExpireAt.Unix())
+		if ban.ExpireAt.After(time.Now().AddDate(100, 0, 0)) {
+			expiry = "never"
+		}
+
+		reason := ban.Reason
+		if !access[fieldBans] {
+			reason = "*Reason hidden*"
+		} else if reason == "" {
+			reason = "No reason provided"
+		}
+
+		banList[i] = fmt.Sprintf("%s (expires %s)", transcript.Truncate(reason, 200), expiry)
+	}
+
+	return banList
+}
+
+// userCosmetics returns the names of the badges and paints a user is entitled to, by kind. Selected ones are marked.
+// Entitlements which are disabled, or conditioned on roles the user doesn't have, are left out
+func userCosmetics(gctx global.Context, user structures.User) (map[string][]string, error) {
+	cur, err := gctx.Inst().Mongo.Collection(mongo.CollectionNameEntitlements).Find(gctx, bson.M{
+		"user_id": user.ID,
+		"kind":    bson.M{"$in": bson.A{"BADGE", "PAINT"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refs := []cosmeticRef{}
+	if err := cur.All(gctx, &refs); err != nil {
+		return nil, err
+	}
+
+	roleIDs := make(map[primitive.ObjectID]struct{}, len(user.Roles))
+	for _, rol := range user.Roles {
+		roleIDs[rol.ID] = struct{}{}
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(refs))
+	selected := map[primitive.ObjectID]bool{}
+
+	for _, ref := range refs {
+		if !ref.applies(roleIDs) {
+			continue
+		}
+
+		ids = append(ids, ref.Data.RefID)
+		selected[ref.Data.RefID] = selected[ref.Data.RefID] || ref.Data.Selected
+	}
+
+	result := map[string][]string{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	cur, err = gctx.Inst().Mongo.Collection(mongo.CollectionNameCosmetics).Find(gctx, bson.M{
+		"_id": bson.M{"$in": ids},
+	}, options.Find().SetProjection(bson.M{"kind": 1, "name": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	cosmetics := []cosmetic{}
+	if err := cur.All(gctx, &cosmetics); err != nil {
+		return nil, err
+	}
+
+	for _, c := range cosmetics {
+		name := c.Name
+		if selected[c.ID] {
+			name = fmt.Sprintf("**%s** (selected)", c.Name)
+		}
+
+		result[c.Kind] = append(result[c.Kind], name)
+	}
+
+	return result, nil
 }
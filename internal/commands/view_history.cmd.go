@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/messages"
+	"github.com/seventv/compactdisc/internal/transcript"
+)
+
+// ViewHistory shows the cached copy of a message and every revision seen since
+func ViewHistory(gctx global.Context, appID string, guildID string) *Command {
+	return DefineCommand(
+		&discordgo.ApplicationCommand{
+			ApplicationID:            appID,
+			GuildID:                  guildID,
+			Type:                     discordgo.MessageApplicationCommand,
+			Name:                     "View History",
+			DefaultMemberPermissions: utils.PointerOf(int64(discordgo.PermissionManageMessages)),
+		},
+		func(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
+			data := interaction.ApplicationCommandData()
+
+			msg, err := gctx.Inst().Messages.Get(gctx, data.TargetID)
+			if errors.Is(err, messages.ErrNotFound) {
+				return respondEphemeral(session, interaction, "This message is not cached")
+			} else if err != nil {
+				return err
+			}
+
+			revisions, err := gctx.Inst().Messages.Revisions(gctx, msg.ID)
+			if err != nil {
+				return err
+			}
+
+			// One page per version of the message, oldest first
+			versions := append(revisions, messages.RevisionOf(msg))
+			pages := make([]*discordgo.MessageEmbed, len(versions))
+
+			for i, rev := range versions {
+				title := fmt.Sprintf("Revision %d", i+1)
+
+				switch {
+				case len(versions) == 1:
+					title = "Cached Message"
+				case i == 0:
+					title += " (original)"
+				case i == len(versions)-1:
+					title += " (latest)"
+				}
+
+				pages[i] = revisionEmbed(msg, rev, title)
+			}
+
+			authorID := ""
+			if msg.Author != nil {
+				authorID = msg.Author.ID
+			}
+
+			return respondPages(gctx, session, interaction, pages, true, authorID)
+		},
+	)
+}
+
+func revisionEmbed(msg *discordgo.Message, rev messages.Revision, title string) *discordgo.MessageEmbed {
+	content := rev.Content
+	if content == "" {
+		content = "*No content*"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       title,
+		URL:         messages.JumpURL(msg),
+		Description: transcript.Truncate(content, 4096),
+		Timestamp:   rev.Timestamp.Format(time.RFC3339),
+		Fields:      []*discordgo.MessageEmbedField{},
+	}
+
+	if msg.Author != nil {
+		embed.Author = &discordgo.MessageEmbedAuthor{
+			Name:    msg.Author.String(),
+			IconURL: msg.Author.AvatarURL("128"),
+		}
+	}
+
+	if len(rev.Attachments) > 0 {
+		a := make([]string, len(rev.Attachments))
+		for i, v := range rev.Attachments {
+			a[i] = v.URL
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Attachments",
+			Value: transcript.Truncate(strings.Join(a, "\n"), 1024),
+		})
+	}
+
+	return embed
+}
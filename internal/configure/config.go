@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
@@ -136,8 +137,85 @@ type Config struct {
 		DefaultRoleId string            `mapstructure:"default_role_id" json:"default_role_id"`
 		Token         string            `mapstructure:"token" json:"token"`
 		Channels      map[string]string `mapstructure:"channels" json:"channels"`
+
+		ShardID      int    `mapstructure:"shard_id" json:"shard_id"`
+		ShardCount   int    `mapstructure:"shard_count" json:"shard_count"`
+		AutoShard    bool   `mapstructure:"auto_shard" json:"auto_shard"`
+		ShardAddress string `mapstructure:"shard_address" json:"shard_address"`
 	} `mapstructure:"discord" json:"discord"`
 
+	Messages struct {
+		// Backend is where messages are cached: redis, memory or mongo
+		Backend    string        `mapstructure:"backend" json:"backend"`
+		Retention  time.Duration `mapstructure:"retention" json:"retention"`
+		Compress   bool          `mapstructure:"compress" json:"compress"`
+		MemorySize int           `mapstructure:"memory_size" json:"memory_size"`
+		Collection string        `mapstructure:"collection" json:"collection"`
+
+		Channels struct {
+			Include   []string                 `mapstructure:"include" json:"include"`
+			Exclude   []string                 `mapstructure:"exclude" json:"exclude"`
+			Retention map[string]time.Duration `mapstructure:"retention" json:"retention"`
+		} `mapstructure:"channels" json:"channels"`
+
+		Encryption struct {
+			Enabled   bool              `mapstructure:"enabled" json:"enabled"`
+			ActiveKey string            `mapstructure:"active_key" json:"active_key"`
+			Keys      map[string]string `mapstructure:"keys" json:"keys"`
+			Migrate   bool              `mapstructure:"migrate" json:"migrate"`
+		} `mapstructure:"encryption" json:"encryption"`
+	} `mapstructure:"messages" json:"messages"`
+
+	Archive struct {
+		Enabled  bool     `mapstructure:"enabled" json:"enabled"`
+		Channels []string `mapstructure:"channels" json:"channels"`
+		MaxSize  int64    `mapstructure:"max_size" json:"max_size"`
+
+		Store struct {
+			Kind string `mapstructure:"kind" json:"kind"`
+			Path string `mapstructure:"path" json:"path"`
+
+			S3 struct {
+				Endpoint  string `mapstructure:"endpoint" json:"endpoint"`
+				Region    string `mapstructure:"region" json:"region"`
+				Bucket    string `mapstructure:"bucket" json:"bucket"`
+				AccessKey string `mapstructure:"access_key" json:"access_key"`
+				SecretKey string `mapstructure:"secret_key" json:"secret_key"`
+				PathStyle bool   `mapstructure:"path_style" json:"path_style"`
+			} `mapstructure:"s3" json:"s3"`
+		} `mapstructure:"store" json:"store"`
+	} `mapstructure:"archive" json:"archive"`
+
+	Unfurl struct {
+		Enabled bool `mapstructure:"enabled" json:"enabled"`
+		// Cooldown is how long a channel waits between two unfurls
+		Cooldown time.Duration `mapstructure:"cooldown" json:"cooldown"`
+	} `mapstructure:"unfurl" json:"unfurl"`
+
+	Modmail struct {
+		Enabled bool `mapstructure:"enabled" json:"enabled"`
+		// Channel is the staff channel in which conversations are relayed as threads
+		Channel string `mapstructure:"channel" json:"channel"`
+	} `mapstructure:"modmail" json:"modmail"`
+
+	UserInfo struct {
+		// Fields maps the sensitive fields of User Info (connections, editors, bans and notes) to who may see them.
+		// Fields without a rule fall back to a default 7TV permission
+		Fields map[string]struct {
+			// Roles are the IDs of the 7TV roles allowed to see the field
+			Roles []string `mapstructure:"roles" json:"roles"`
+			// Permissions are 7TV permission bits allowing to see the field when all of them are held
+			Permissions int64 `mapstructure:"permissions" json:"permissions"`
+		} `mapstructure:"fields" json:"fields"`
+		// LookupRetention is how long the audit of user lookups is kept for, 90 days when unset
+		LookupRetention time.Duration `mapstructure:"lookup_retention" json:"lookup_retention"`
+	} `mapstructure:"user_info" json:"user_info"`
+
+	Leader struct {
+		Enabled bool          `mapstructure:"enabled" json:"enabled"`
+		Lease   time.Duration `mapstructure:"lease" json:"lease"`
+	} `mapstructure:"leader" json:"leader"`
+
 	Redis struct {
 		Username   string   `mapstructure:"username" json:"username"`
 		Password   string   `mapstructure:"password" json:"password"`
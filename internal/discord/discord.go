@@ -3,6 +3,8 @@ package discord
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -10,18 +12,41 @@ import (
 type Instance interface {
 	Session() *discordgo.Session
 	Identity() *discordgo.User
+	// ShardID returns the shard this session is connected as
+	ShardID() int
+	// ShardCount returns the total number of shards
+	ShardCount() int
+	// OwnsGuild returns whether the guild's events are received by this shard
+	OwnsGuild(guildID string) bool
+	// WaitGuild blocks until the state of a guild, i.e its channels and threads, is loaded from the gateway
+	WaitGuild(ctx context.Context, guildID string) error
+}
+
+type Options struct {
+	Token      string
+	ShardID    int
+	ShardCount int
 }
 
 type discordInst struct {
 	ses *discordgo.Session
 }
 
-func New(ctx context.Context, token string) (Instance, error) {
-	ses, err := discordgo.New(fmt.Sprintf("Bot %s", token))
+func New(ctx context.Context, opt Options) (Instance, error) {
+	ses, err := discordgo.New(fmt.Sprintf("Bot %s", opt.Token))
 	if err != nil {
 		return nil, err
 	}
 
+	if opt.ShardCount > 1 {
+		if opt.ShardID < 0 || opt.ShardID >= opt.ShardCount {
+			return nil, fmt.Errorf("shard id %d is out of range for %d shards", opt.ShardID, opt.ShardCount)
+		}
+
+		ses.ShardID = opt.ShardID
+		ses.ShardCount = opt.ShardCount
+	}
+
 	// Open connection to discord gateway
 	ses.Identify.Intents = discordgo.MakeIntent(ses.Identify.Intents | discordgo.IntentMessageContent | discordgo.IntentsGuildMembers | discordgo.IntentDirectMessages)
 	if err := ses.Open(); err != nil {
@@ -45,3 +70,82 @@ func (di *discordInst) Session() *discordgo.Session {
 func (di *discordInst) Identity() *discordgo.User {
 	return di.ses.State.User
 }
+
+func (di *discordInst) ShardID() int {
+	return di.ses.ShardID
+}
+
+func (di *discordInst) ShardCount() int {
+	if di.ses.ShardCount < 1 {
+		return 1
+	}
+
+	return di.ses.ShardCount
+}
+
+func (di *discordInst) OwnsGuild(guildID string) bool {
+	return ShardOf(guildID, di.ShardCount()) == di.ShardID()
+}
+
+func (di *discordInst) WaitGuild(ctx context.Context, guildID string) error {
+	loaded := make(chan struct{})
+	once := sync.Once{}
+
+	// The state is updated before handlers run, so a guild created meanwhile is seen by either the handler or the check below
+	remove := di.ses.AddHandler(func(s *discordgo.Session, g *discordgo.GuildCreate) {
+		if g.ID == guildID && !g.Unavailable {
+			once.Do(func() { close(loaded) })
+		}
+	})
+	defer remove()
+
+	if g, err := di.ses.State.Guild(guildID); err == nil && !g.Unavailable {
+		return nil
+	}
+
+	select {
+	case <-loaded:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShardOf returns the shard which receives the events of a guild.
+// Direct messages, which have no guild, are always sent to shard 0
+func ShardOf(guildID string, shardCount int) int {
+	if shardCount <= 1 || guildID == "" {
+		return 0
+	}
+
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return int((id >> 22) % uint64(shardCount))
+}
+
+// ShardFromPodName derives a shard ID from the ordinal of a statefulset pod, such as "compactdisc-2"
+func ShardFromPodName(podName string, shardCount int) (int, error) {
+	i := len(podName) - 1
+	for i >= 0 && podName[i] >= '0' && podName[i] <= '9' {
+		i--
+	}
+
+	if i == len(podName)-1 {
+		return 0, fmt.Errorf("pod name %q has no ordinal", podName)
+	}
+
+	ordinal, err := strconv.Atoi(podName[i+1:])
+	if err != nil {
+		return 0, err
+	}
+
+	if shardCount < 1 {
+		shardCount = 1
+	}
+
+	// Extra pods beyond the shard count become standbys of an existing shard
+	return ordinal % shardCount, nil
+}
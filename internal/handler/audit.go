@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-redis/redis/v8"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.uber.org/zap"
+)
+
+const (
+	// auditLogAttempts is how many times the audit log is polled for an entry, since it can be written after the gateway event is sent
+	auditLogAttempts = 3
+	// auditLogDelay is the delay between polls of the audit log
+	auditLogDelay = time.Second * 2
+	// auditLogWindow is how old an audit log entry may be to be considered related to an event
+	auditLogWindow = time.Second * 30
+)
+
+// findAuditEntry looks up the most recent audit log entry of an action against a target
+func findAuditEntry(s *discordgo.Session, guildID string, action discordgo.AuditLogAction, targetID string) *discordgo.AuditLogEntry {
+	return findAuditEntryOf(s, guildID, targetID, action)
+}
+
+// findAuditEntryOf looks up the most recent audit log entry of any of several actions against a target,
+// for events which can have different causes such as a member being removed by a kick or a ban
+func findAuditEntryOf(s *discordgo.Session, guildID string, targetID string, actions ...discordgo.AuditLogAction) *discordgo.AuditLogEntry {
+	// A single action can be filtered by discord, otherwise the whole log is searched
+	filter := 0
+	if len(actions) == 1 {
+		filter = int(actions[0])
+	}
+
+	for attempt := 0; attempt < auditLogAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(auditLogDelay)
+		}
+
+		log, err := s.GuildAuditLog(guildID, "", "", filter, 25)
+		if err != nil {
+			zap.S().Warnw("failed to fetch audit log", "error", err, "actions", actions)
+			return nil
+		}
+
+		for _, entry := range log.AuditLogEntries {
+			if entry.TargetID != targetID || entry.ActionType == nil {
+				continue
+			}
+
+			matches := false
+
+			for _, action := range actions {
+				if *entry.ActionType == action {
+					matches = true
+					break
+				}
+			}
+
+			if !matches {
+				continue
+			}
+
+			if ts, err := discordgo.SnowflakeTimestamp(entry.ID); err != nil || time.Since(ts) > auditLogWindow {
+				continue
+			}
+
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// auditFields returns embed fields describing the moderator and reason of an audit log entry
+func auditFields(entry *discordgo.AuditLogEntry) []*discordgo.MessageEmbedField {
+	if entry == nil {
+		return []*discordgo.MessageEmbedField{}
+	}
+
+	reason := entry.Reason
+	if reason == "" {
+		reason = "No reason provided"
+	}
+
+	return []*discordgo.MessageEmbedField{
+		{
+			Name:   "Moderator",
+			Value:  "<@" + entry.UserID + ">",
+			Inline: true,
+		},
+		{
+			Name:   "Reason",
+			Value:  transcript.Truncate(reason, 1024),
+			Inline: true,
+		},
+	}
+}
+
+// deleter is who a message deletion was attributed to
+type deleter struct {
+	UserID string
+	// Certain is false when no audit log entry was found in time, in which case the author is assumed to have deleted the message
+	Certain bool
+}
+
+// findMessageDeleter attributes a message deletion to the moderator who performed it.
+// Discord groups consecutive deletions by the same moderator into a single entry whose count increases,
+// so the counts already seen are remembered in redis to recognize a reused entry.
+// Discord doesn't log deletions by the author, but an entry may also be missed, so finding none isn't proof of one
+func findMessageDeleter(gctx global.Context, s *discordgo.Session, msg *discordgo.Message) deleter {
+	for attempt := 0; attempt < auditLogAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(auditLogDelay)
+		}
+
+		log, err := s.GuildAuditLog(msg.GuildID, "", "", int(discordgo.AuditLogActionMessageDelete), 25)
+		if err != nil {
+			zap.S().Warnw("failed to fetch audit log", "error", err)
+			break
+		}
+
+		for _, entry := range log.AuditLogEntries {
+			if entry.TargetID != msg.Author.ID || entry.Options == nil || entry.Options.ChannelID != msg.ChannelID {
+				continue
+			}
+
+			count, _ := strconv.Atoi(entry.Options.Count)
+			key := gctx.Inst().Redis.ComposeKey("compactdisc", "audit", "message_delete", entry.ID).String()
+
+			previous, err := gctx.Inst().Redis.RawClient().GetSet(gctx, key, count).Int()
+			if err != nil && err != redis.Nil {
+				zap.S().Warnw("failed to track audit log entry", "error", err)
+				continue
+			}
+
+			_ = gctx.Inst().Redis.RawClient().Expire(gctx, key, time.Minute*10).Err()
+
+			if err == redis.Nil { // first time this entry is seen, it must have been created for this deletion
+				if ts, err := discordgo.SnowflakeTimestamp(entry.ID); err != nil || time.Since(ts) > auditLogWindow {
+					continue
+				}
+			} else if count <= previous {
+				continue // the entry was not updated for this deletion
+			}
+
+			return deleter{
+				UserID:  entry.UserID,
+				Certain: true,
+			}
+		}
+	}
+
+	return deleter{
+		UserID:  msg.Author.ID,
+		Certain: false,
+	}
+}
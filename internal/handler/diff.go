@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/seventv/compactdisc/internal/transcript"
+)
+
+// diffLines renders a line-by-line diff of two texts in the format of a diff code block
+func diffLines(before, after string) string {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	// Longest common subsequence table
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	sb := strings.Builder{}
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// codeBlock wraps text in a code block, truncating it to fit within limit characters
+func codeBlock(lang string, text string, limit int) string {
+	text = strings.ReplaceAll(text, "```", "`​``")
+
+	return "```" + lang + "\n" + transcript.Truncate(text, limit-len("```"+lang+"\n")-len("\n```")) + "\n```"
+}
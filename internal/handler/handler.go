@@ -1,38 +1,66 @@
 package handler
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/archive"
 	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/leader"
+	"github.com/seventv/compactdisc/internal/messages"
+	"github.com/seventv/compactdisc/internal/purge"
+	"github.com/seventv/compactdisc/internal/transcript"
 	"go.uber.org/zap"
 )
 
 func Register(gctx global.Context, session *discordgo.Session) {
-	session.AddHandler(messageCreate(gctx))
-	session.AddHandler(messageDelete(gctx))
-	session.AddHandler(guildMemberAdd(gctx))
+	ldr := gctx.Inst().Leader
+
+	session.AddHandler(leader.Only(ldr, messageCreate(gctx)))
+	session.AddHandler(leader.Only(ldr, messageUpdate(gctx)))
+	session.AddHandler(leader.Only(ldr, messageDelete(gctx)))
+	session.AddHandler(leader.Only(ldr, messageDeleteBulk(gctx)))
+	session.AddHandler(leader.Only(ldr, guildMemberAdd(gctx)))
+	session.AddHandler(leader.Only(ldr, guildMemberUpdate(gctx)))
+	session.AddHandler(leader.Only(ldr, guildMemberRemove(gctx)))
+	session.AddHandler(leader.Only(ldr, guildBanAdd(gctx)))
+	session.AddHandler(leader.Only(ldr, guildBanRemove(gctx)))
+	session.AddHandler(leader.Only(ldr, guildRoleUpdate(gctx)))
+	session.AddHandler(leader.Only(ldr, guildRoleDelete(gctx)))
 }
 
 // messageCreate is a handler for messages
 func messageCreate(gctx global.Context) func(s *discordgo.Session, m *discordgo.MessageCreate) {
+	var pattern *regexp.Regexp
+	if gctx.Config().Unfurl.Enabled {
+		pattern = unfurlPattern(gctx)
+	}
+
 	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
 		if m.Member == nil {
 			return
 		}
 
-		// Store the message in cache for 24h
-		key := gctx.Inst().Redis.ComposeKey("compactdisc", "cache", "message", m.ID)
-
-		j, _ := json.Marshal(m.Message)
-		if err := gctx.Inst().Redis.SetEX(gctx, key, utils.B2S(j), time.Hour*72); err != nil {
+		// Store the message in cache
+		if err := gctx.Inst().Messages.Set(gctx, m.Message); err != nil {
 			zap.S().Errorw("failed to store message in cache", "error", err)
 		}
 
+		// Archive the attachments, as their links stop working once the message is deleted
+		if len(m.Attachments) > 0 {
+			go archive.Store(gctx, m.Message)
+		}
+
+		// Replace the generic previews of 7TV links
+		if pattern != nil && !m.Author.Bot {
+			go unfurl(gctx, s, pattern, m.Message)
+		}
+
 		// Assign default role if user does not have it
 		if gctx.Config().Discord.DefaultRoleId != "" && !utils.Contains(m.Member.Roles, gctx.Config().Discord.DefaultRoleId) {
 			finalRoles := append(m.Member.Roles, gctx.Config().Discord.DefaultRoleId)
@@ -48,18 +76,28 @@ func messageCreate(gctx global.Context) func(s *discordgo.Session, m *discordgo.
 
 func messageDelete(gctx global.Context) func(s *discordgo.Session, m *discordgo.MessageDelete) {
 	return func(s *discordgo.Session, m *discordgo.MessageDelete) {
-		key := gctx.Inst().Redis.ComposeKey("compactdisc", "cache", "message", m.ID)
+		if purge.Purged(gctx, m.ID)[m.ID] {
+			return // logged by the purge command
+		}
+
+		msg, err := gctx.Inst().Messages.Get(gctx, m.ID)
+		if errors.Is(err, messages.ErrDecrypt) {
+			// The content is lost, but the deletion is still worth logging
+			zap.S().Warnw("failed to decrypt cached message", "error", err, "message_id", m.ID)
+
+			if _, err := s.ChannelMessageSendEmbed(gctx.Config().Discord.Channels["mod_logs"], &discordgo.MessageEmbed{
+				Description: fmt.Sprintf("❌ **Message %s deleted in <#%s>**\nIts cached copy could not be decrypted", m.ID, m.ChannelID),
+				Color:       0xFF0000,
+				Timestamp:   time.Now().Format(time.RFC3339),
+			}); err != nil {
+				zap.S().Errorw("failed to send embed", "error", err)
+			}
 
-		data, err := gctx.Inst().Redis.Get(gctx, key)
-		if err != nil {
-			zap.S().Errorw("failed to get message from cache", "error", err)
 			return
 		}
 
-		var msg *discordgo.Message
-		if err := json.Unmarshal([]byte(data), &msg); err != nil {
-			zap.S().Errorw("failed to unmarshal message from cache", "error", err)
-
+		if err != nil {
+			zap.S().Errorw("failed to get message from cache", "error", err)
 			return
 		}
 
@@ -86,6 +124,44 @@ func messageDelete(gctx global.Context) func(s *discordgo.Session, m *discordgo.
 			})
 		}
 
+		// Find out who deleted the message
+		by := findMessageDeleter(gctx, s, msg)
+
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name: "Deleted By",
+			Value: func() string {
+				if by.Certain {
+					return fmt.Sprintf("<@%s>", by.UserID)
+				}
+
+				return fmt.Sprintf("<@%s>, likely the author (not confirmed by the audit log)", by.UserID)
+			}(),
+		})
+
+		// List the previous versions of the message if it was edited
+		if revisions, _ := gctx.Inst().Messages.Revisions(gctx, msg.ID); len(revisions) > 0 {
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name: fmt.Sprintf("Revisions (%d)", len(revisions)),
+				Value: func() string {
+					a := make([]string, len(revisions))
+					for i, rev := range revisions {
+						a[i] = fmt.Sprintf("<t:%d:T> %s", rev.Timestamp.Unix(), transcript.Truncate(rev.Content, 100))
+					}
+
+					return transcript.Truncate(strings.Join(a, "\n"), 1024)
+				}(),
+			})
+		}
+
+		// Remind moderators of the notes kept about the author.
+		// They are sent to the notes channel instead when one is configured, for logs read by staff who may not see notes
+		notesField := authorNotes(gctx, msg.Author.ID)
+		notesChannel := gctx.Config().Discord.Channels["mod_notes"]
+
+		if notesField != nil && notesChannel == "" {
+			fields = append(fields, notesField)
+		}
+
 		embed := &discordgo.MessageEmbed{
 			Author: &discordgo.MessageEmbedAuthor{
 				Name:    msg.Author.Username,
@@ -97,9 +173,24 @@ func messageDelete(gctx global.Context) func(s *discordgo.Session, m *discordgo.
 			Fields:      fields,
 		}
 
-		if _, err := s.ChannelMessageSendEmbed(gctx.Config().Discord.Channels["mod_logs"], embed); err != nil {
+		if _, err := s.ChannelMessageSendComplex(gctx.Config().Discord.Channels["mod_logs"], &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Files:  archive.Files(gctx, msg),
+		}); err != nil {
 			zap.S().Errorw("failed to send embed", "error", err)
 		}
+
+		if notesField != nil && notesChannel != "" {
+			if _, err := s.ChannelMessageSendEmbed(notesChannel, &discordgo.MessageEmbed{
+				Author:      embed.Author,
+				Description: fmt.Sprintf("A message by %s was deleted in <#%s>", msg.Author.Mention(), msg.ChannelID),
+				Color:       0xFF0000,
+				Timestamp:   embed.Timestamp,
+				Fields:      []*discordgo.MessageEmbedField{notesField},
+			}); err != nil {
+				zap.S().Errorw("failed to send notes", "error", err)
+			}
+		}
 	}
 }
 
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// linkedUser returns the 7TV user linked to a discord account
+func linkedUser(gctx global.Context, discordID string) (structures.User, error) {
+	return gctx.Inst().Query.Users(gctx, bson.M{
+		"connections": bson.M{"$elemMatch": bson.M{
+			"platform": structures.UserConnectionPlatformDiscord,
+			"id":       discordID,
+		}},
+	}).First()
+}
+
+// linkedUserValue returns a link to the 7TV user linked to a discord account, for use in an embed
+func linkedUserValue(gctx global.Context, discordID string) string {
+	user, err := linkedUser(gctx, discordID)
+	if err != nil {
+		return "None"
+	}
+
+	return fmt.Sprintf("[%s (%s)](%s)", user.DisplayName, user.Username, user.WebURL(gctx.Config().WebsiteURL))
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.uber.org/zap"
+)
+
+// guildMemberRemove is a handler for leaves and kicks
+func guildMemberRemove(gctx global.Context) func(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	return func(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+		if m.User == nil {
+			return
+		}
+
+		desc := fmt.Sprintf("📤 **%s left the server**", m.User.Mention())
+
+		entry := findAuditEntryOf(s, m.GuildID, m.User.ID, discordgo.AuditLogActionMemberKick, discordgo.AuditLogActionMemberBanAdd)
+
+		switch {
+		case entry != nil && *entry.ActionType == discordgo.AuditLogActionMemberBanAdd:
+			return // bans are logged by guildBanAdd
+		case entry != nil:
+			desc = fmt.Sprintf("👢 **%s was kicked from the server**", m.User.Mention())
+		default:
+			// The audit log entry of a ban may not be written in time, but the ban itself is
+			if ban, err := s.GuildBan(m.GuildID, m.User.ID); err == nil && ban != nil {
+				return
+			}
+		}
+
+		sendMemberLog(gctx, s, m.User, desc, 0xFFA500, entry)
+	}
+}
+
+// guildBanAdd is a handler for bans
+func guildBanAdd(gctx global.Context) func(s *discordgo.Session, m *discordgo.GuildBanAdd) {
+	return func(s *discordgo.Session, m *discordgo.GuildBanAdd) {
+		entry := findAuditEntry(s, m.GuildID, discordgo.AuditLogActionMemberBanAdd, m.User.ID)
+
+		sendMemberLog(gctx, s, m.User, fmt.Sprintf("🔨 **%s was banned**", m.User.Mention()), 0xFF0000, entry)
+	}
+}
+
+// guildBanRemove is a handler for unbans
+func guildBanRemove(gctx global.Context) func(s *discordgo.Session, m *discordgo.GuildBanRemove) {
+	return func(s *discordgo.Session, m *discordgo.GuildBanRemove) {
+		entry := findAuditEntry(s, m.GuildID, discordgo.AuditLogActionMemberBanRemove, m.User.ID)
+
+		sendMemberLog(gctx, s, m.User, fmt.Sprintf("🕊️ **%s was unbanned**", m.User.Mention()), 0x00FF00, entry)
+	}
+}
+
+// guildMemberUpdate is a handler for member updates, which logs timeouts
+func guildMemberUpdate(gctx global.Context) func(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	return func(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+		if m.Member == nil || m.User == nil {
+			return
+		}
+
+		// The previous timeout is remembered in redis, as member updates don't carry the previous state
+		key := gctx.Inst().Redis.ComposeKey("compactdisc", "timeout", m.GuildID, m.User.ID)
+		previous, _ := gctx.Inst().Redis.Get(gctx, key)
+
+		until := m.CommunicationDisabledUntil
+		if until != nil && until.After(time.Now()) {
+			unix := strconv.FormatInt(until.Unix(), 10)
+			if previous == unix {
+				return // timeout is unchanged
+			}
+
+			if err := gctx.Inst().Redis.SetEX(gctx, key, unix, time.Until(*until)); err != nil {
+				zap.S().Errorw("failed to store timeout", "error", err)
+			}
+
+			entry := findAuditEntry(s, m.GuildID, discordgo.AuditLogActionMemberUpdate, m.User.ID)
+
+			sendMemberLog(gctx, s, m.User, fmt.Sprintf("🔇 **%s was timed out until <t:%s:f>** (<t:%s:R>)", m.User.Mention(), unix, unix), 0xFFA500, entry)
+
+			return
+		}
+
+		if previous == "" {
+			return // was not timed out
+		}
+
+		if err := gctx.Inst().Redis.Del(gctx, key); err != nil {
+			zap.S().Errorw("failed to delete timeout", "error", err)
+		}
+
+		entry := findAuditEntry(s, m.GuildID, discordgo.AuditLogActionMemberUpdate, m.User.ID)
+
+		sendMemberLog(gctx, s, m.User, fmt.Sprintf("🔊 **%s's timeout was removed**", m.User.Mention()), 0x00FF00, entry)
+	}
+}
+
+// sendMemberLog posts an embed about a member to the mod logs
+func sendMemberLog(gctx global.Context, s *discordgo.Session, user *discordgo.User, description string, color int, entry *discordgo.AuditLogEntry) {
+	fields := []*discordgo.MessageEmbedField{
+		{
+			Name:   "User",
+			Value:  fmt.Sprintf("%s (%s)", user.String(), user.ID),
+			Inline: true,
+		},
+		{
+			Name:   "7TV Account",
+			Value:  linkedUserValue(gctx, user.ID),
+			Inline: true,
+		},
+	}
+
+	fields = append(fields, auditFields(entry)...)
+
+	embed := &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    user.Username,
+			IconURL: user.AvatarURL("128"),
+		},
+		Description: description,
+		Color:       color,
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Fields:      fields,
+	}
+
+	if _, err := s.ChannelMessageSendEmbed(gctx.Config().Discord.Channels["mod_logs"], embed); err != nil {
+		zap.S().Errorw("failed to send embed", "error", err)
+	}
+}
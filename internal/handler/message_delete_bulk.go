@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/purge"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.uber.org/zap"
+)
+
+// messageDeleteBulk is a handler for purges
+func messageDeleteBulk(gctx global.Context) func(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	return func(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+		// The messages deleted by a purge are logged by the purge command
+		purged := purge.Purged(gctx, m.Messages...)
+		ids := make([]string, 0, len(m.Messages))
+
+		for _, id := range m.Messages {
+			if !purged[id] {
+				ids = append(ids, id)
+			}
+		}
+
+		if len(ids) == 0 {
+			return
+		}
+
+		msgs, err := gctx.Inst().Messages.GetMany(gctx, ids)
+		if err != nil {
+			zap.S().Errorw("failed to get messages from cache", "error", err)
+			return
+		}
+
+		transcript.Sort(msgs)
+
+		title := fmt.Sprintf("Bulk deletion of %d messages in #%s", len(ids), channelName(s, m.ChannelID))
+		name := fmt.Sprintf("bulk-delete-%s-%d", m.ChannelID, time.Now().Unix())
+
+		files, err := transcript.Files(name, title, msgs)
+		if err != nil {
+			zap.S().Errorw("failed to render transcript", "error", err)
+			return
+		}
+
+		embed := &discordgo.MessageEmbed{
+			Description: fmt.Sprintf("🗑️ **%d messages bulk deleted in <#%s>**\n%d of them were cached and are included in the transcript", len(ids), m.ChannelID, len(msgs)),
+			Color:       0xFF0000,
+			Timestamp:   time.Now().Format(time.RFC3339),
+			Fields:      []*discordgo.MessageEmbedField{},
+		}
+
+		if authors := transcript.Authors(msgs); len(authors) > 0 {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name: fmt.Sprintf("Authors (%d)", len(authors)),
+				Value: func() string {
+					a := make([]string, len(authors))
+					for i, ac := range authors {
+						a[i] = fmt.Sprintf("<@%s> — %d", ac.ID, ac.Count)
+					}
+
+					return transcript.Truncate(strings.Join(a, "\n"), 1024)
+				}(),
+			})
+		}
+
+		if _, err := s.ChannelMessageSendComplex(gctx.Config().Discord.Channels["mod_logs"], &discordgo.MessageSend{
+			Embeds:          []*discordgo.MessageEmbed{embed},
+			Files:           files,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}); err != nil {
+			zap.S().Errorw("failed to send transcript", "error", err)
+		}
+	}
+}
+
+// channelName returns the name of a channel, or its ID if it is unknown
+func channelName(s *discordgo.Session, channelID string) string {
+	if ch, err := s.State.Channel(channelID); err == nil {
+		return ch.Name
+	}
+
+	return channelID
+}
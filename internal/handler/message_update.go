@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/messages"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.uber.org/zap"
+)
+
+// messageUpdate is a handler for message edits
+func messageUpdate(gctx global.Context) func(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	return func(s *discordgo.Session, m *discordgo.MessageUpdate) {
+		if m.EditedTimestamp == nil {
+			return // not an edit, i.e embeds being resolved
+		}
+
+		msg, err := gctx.Inst().Messages.Get(gctx, m.ID)
+		if err != nil {
+			zap.S().Errorw("failed to get message from cache", "error", err)
+			return
+		}
+
+		before := messages.RevisionOf(msg)
+
+		addedAttachments, removedAttachments := diffAttachments(msg.Attachments, m.Attachments)
+		if before.Content == m.Content && len(addedAttachments) == 0 && len(removedAttachments) == 0 {
+			return // nothing we log has changed
+		}
+
+		// Keep the previous version in the message's history, then update the cached copy
+		if err := gctx.Inst().Messages.AddRevision(gctx, msg, before); err != nil {
+			zap.S().Errorw("failed to store message revision", "error", err)
+		}
+
+		msg.Content = m.Content
+		msg.Attachments = m.Attachments
+		msg.EditedTimestamp = m.EditedTimestamp
+
+		if err := gctx.Inst().Messages.Set(gctx, msg); err != nil {
+			zap.S().Errorw("failed to update message in cache", "error", err)
+		}
+
+		// Create an embed of the edit
+		fields := []*discordgo.MessageEmbedField{}
+		if before.Content != msg.Content {
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:  "Changes",
+				Value: codeBlock("diff", diffLines(before.Content, msg.Content), 1024),
+			})
+		}
+
+		if len(removedAttachments) > 0 {
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:  "Removed Attachments",
+				Value: transcript.Truncate(strings.Join(removedAttachments, "\n"), 1024),
+			})
+		}
+
+		if len(addedAttachments) > 0 {
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:  "Added Attachments",
+				Value: transcript.Truncate(strings.Join(addedAttachments, "\n"), 1024),
+			})
+		}
+
+		revisions, _ := gctx.Inst().Messages.Revisions(gctx, msg.ID)
+
+		embed := &discordgo.MessageEmbed{
+			Author: &discordgo.MessageEmbedAuthor{
+				Name:    msg.Author.Username,
+				IconURL: msg.Author.AvatarURL("128"),
+			},
+			Description: fmt.Sprintf("✏️ **Message by %s edited in <#%s>** [Jump to Message](%s)\n", msg.Author.Mention(), msg.ChannelID, messages.JumpURL(msg)),
+			Color:       0xFFA500,
+			Timestamp:   msg.EditedTimestamp.Format(time.RFC3339),
+			Fields:      fields,
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Revision %d", len(revisions)+1),
+			},
+		}
+
+		if _, err := s.ChannelMessageSendEmbed(gctx.Config().Discord.Channels["mod_logs"], embed); err != nil {
+			zap.S().Errorw("failed to send embed", "error", err)
+		}
+	}
+}
+
+// diffAttachments returns the URLs of the attachments which were added and removed
+func diffAttachments(before, after []*discordgo.MessageAttachment) (added []string, removed []string) {
+	beforeIDs := make(map[string]struct{}, len(before))
+	for _, a := range before {
+		beforeIDs[a.ID] = struct{}{}
+	}
+
+	afterIDs := make(map[string]struct{}, len(after))
+	for _, a := range after {
+		afterIDs[a.ID] = struct{}{}
+
+		if _, ok := beforeIDs[a.ID]; !ok {
+			added = append(added, a.URL)
+		}
+	}
+
+	for _, a := range before {
+		if _, ok := afterIDs[a.ID]; !ok {
+			removed = append(removed, a.URL)
+		}
+	}
+
+	return added, removed
+}
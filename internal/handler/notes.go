@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/notes"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.uber.org/zap"
+)
+
+// notesPreview is how many of the most recent notes about a user are shown in a log
+const notesPreview = 3
+
+// authorNotes returns a field listing the most recent notes about a discord member, including those written about
+// their linked 7TV account. Nil is returned if there are none
+func authorNotes(gctx global.Context, discordID string) *discordgo.MessageEmbedField {
+	subject := notes.Subject{DiscordID: discordID}
+
+	user, err := rolesync.LinkedUser(gctx, gctx, discordID)
+	if err == nil {
+		subject.UserID = user.ID
+	} else if !errors.Is(err, rolesync.ErrNotLinked) {
+		zap.S().Errorw("failed to get the 7TV account of a member", "error", err, "discord_id", discordID)
+	}
+
+	list, err := notes.List(gctx, gctx, subject)
+	if err != nil {
+		zap.S().Errorw("failed to list notes", "error", err)
+		return nil
+	}
+
+	if len(list) == 0 {
+		return nil
+	}
+
+	return &discordgo.MessageEmbedField{
+		Name:  fmt.Sprintf("Notes (%d)", len(list)),
+		Value: notesValue(list),
+	}
+}
+
+// notesValue lists the most recent notes about a user, for use in an embed
+func notesValue(list []notes.Note) string {
+	lines := []string{}
+
+	for i, note := range list {
+		if i == notesPreview {
+			lines = append(lines, fmt.Sprintf("*and %d more, see /notes list*", len(list)-notesPreview))
+			break
+		}
+
+		lines = append(lines, fmt.Sprintf("<t:%d:d> <@%s>: %s", note.CreatedAt.Unix(), note.AuthorID, transcript.Truncate(note.Content, 200)))
+	}
+
+	return transcript.Truncate(strings.Join(lines, "\n"), 1024)
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"go.uber.org/zap"
+)
+
+// driftAlertCooldown is how long an alert about the same drift of a role is not repeated for,
+// as discord sends an update for every role whose position shifted whenever roles are moved
+const driftAlertCooldown = time.Hour
+
+// guildRoleUpdate alerts when a role linked to a 7TV role is edited so that it no longer matches it
+func guildRoleUpdate(gctx global.Context) func(s *discordgo.Session, e *discordgo.GuildRoleUpdate) {
+	return func(s *discordgo.Session, e *discordgo.GuildRoleUpdate) {
+		if e.GuildRole == nil || e.Role == nil || e.GuildID != gctx.Config().Discord.GuildID {
+			return
+		}
+
+		if rolesync.Styling(gctx, e.GuildID) {
+			return // the bot is moving roles around, which is expected to cause drift until it's done
+		}
+
+		rol, ok := linkedAppRole(gctx, e.Role.ID)
+		if !ok {
+			return
+		}
+
+		appRoles, err := gctx.Inst().Roles.AppRoles(gctx)
+		if err != nil {
+			zap.S().Errorw("failed to fetch app roles", "error", err)
+			return
+		}
+
+		guildRoles, err := gctx.Inst().Roles.GuildRoles(e.GuildID)
+		if err != nil {
+			zap.S().Errorw("failed to fetch guild roles", "error", err)
+			return
+		}
+
+		drift := rolesync.Drift(appRoles, guildRoles, rol, e.Role)
+		if len(drift) == 0 {
+			return
+		}
+
+		description := strings.Join(drift, "\n")
+
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(description))
+
+		entry := findAuditEntry(s, e.GuildID, discordgo.AuditLogActionRoleUpdate, e.Role.ID)
+		if entry != nil && entry.UserID == s.State.User.ID {
+			return // partial edits by the bot itself
+		}
+
+		// Only start the cooldown once the alert is certain to be sent, so that the bot's own edits don't silence it
+		ok, err = gctx.Inst().Redis.RawClient().SetNX(gctx,
+			gctx.Inst().Redis.ComposeKey("compactdisc", "role_drift", e.Role.ID, strconv.FormatUint(h.Sum64(), 16)).String(), "1", driftAlertCooldown,
+		).Result()
+		if err != nil || !ok {
+			return
+		}
+
+		sendRoleLog(gctx, s, fmt.Sprintf("⚠️ **<@&%s> no longer matches the 7TV role %s**\n%s", e.Role.ID, rol.Name, description), 0xFFA500, entry)
+	}
+}
+
+// guildRoleDelete alerts when a role linked to a 7TV role is deleted, as it stops being synced
+func guildRoleDelete(gctx global.Context) func(s *discordgo.Session, e *discordgo.GuildRoleDelete) {
+	return func(s *discordgo.Session, e *discordgo.GuildRoleDelete) {
+		if e.GuildID != gctx.Config().Discord.GuildID {
+			return
+		}
+
+		rol, ok := linkedAppRole(gctx, e.RoleID)
+		if !ok {
+			return
+		}
+
+		entry := findAuditEntry(s, e.GuildID, discordgo.AuditLogActionRoleDelete, e.RoleID)
+
+		sendRoleLog(gctx, s, fmt.Sprintf("🗑️ **The discord role linked to the 7TV role %s was deleted** (%s)", rol.Name, e.RoleID), 0xFF0000, entry)
+	}
+}
+
+// linkedAppRole returns the 7TV role linked to a discord role
+func linkedAppRole(gctx global.Context, discordID string) (structures.Role, bool) {
+	appRoles, err := gctx.Inst().Roles.AppRoles(gctx)
+	if err != nil {
+		zap.S().Errorw("failed to fetch app roles", "error", err)
+		return structures.Role{}, false
+	}
+
+	for _, rol := range appRoles {
+		if rol.DiscordID != 0 && strconv.FormatUint(rol.DiscordID, 10) == discordID {
+			return rol, true
+		}
+	}
+
+	return structures.Role{}, false
+}
+
+// sendRoleLog posts an embed about a linked role to the mod logs
+func sendRoleLog(gctx global.Context, s *discordgo.Session, description string, color int, entry *discordgo.AuditLogEntry) {
+	embed := &discordgo.MessageEmbed{
+		Description: description,
+		Color:       color,
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Fields:      auditFields(entry),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Use /roles style or /roles provision to restore it",
+		},
+	}
+
+	if _, err := s.ChannelMessageSendEmbed(gctx.Config().Discord.Channels["mod_logs"], embed); err != nil {
+		zap.S().Errorw("failed to send embed", "error", err)
+	}
+}
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// UnfurlDismissPrefix prefixes the custom ID of the button removing an unfurl, followed by the ID of the user who posted the link
+	UnfurlDismissPrefix = "unfurl:dismiss:"
+	// unfurlMaxLinks is how many links of a single message are unfurled
+	unfurlMaxLinks = 3
+)
+
+type unfurlLink struct {
+	kind string
+	id   primitive.ObjectID
+}
+
+// unfurlPattern returns a pattern matching emote, user and emote set links of the website
+func unfurlPattern(gctx global.Context) *regexp.Regexp {
+	hosts := []string{}
+
+	for _, origin := range []string{gctx.Config().WebsiteURL, gctx.Config().OldWebsiteURL} {
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			hosts = append(hosts, regexp.QuoteMeta(u.Host))
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	return regexp.MustCompile(fmt.Sprintf(`https?://(?:www\.)?(?:%s)/(emotes|users|emote-sets)/([0-9a-fA-F]{24})`, strings.Join(hosts, "|")))
+}
+
+// unfurlLinks returns the distinct 7TV links of a message
+func unfurlLinks(pattern *regexp.Regexp, content string) []unfurlLink {
+	links := []unfurlLink{}
+	seen := map[string]bool{}
+
+	for _, match := range pattern.FindAllStringSubmatch(content, -1) {
+		id, err := primitive.ObjectIDFromHex(match[2])
+		if err != nil || seen[match[1]+id.Hex()] {
+			continue
+		}
+
+		seen[match[1]+id.Hex()] = true
+		links = append(links, unfurlLink{kind: match[1], id: id})
+
+		if len(links) == unfurlMaxLinks {
+			break
+		}
+	}
+
+	return links
+}
+
+// unfurl replies to a message containing 7TV links with embeds built from live data
+func unfurl(gctx global.Context, s *discordgo.Session, pattern *regexp.Regexp, msg *discordgo.Message) {
+	links := unfurlLinks(pattern, msg.Content)
+	if len(links) == 0 {
+		return
+	}
+
+	cooldown := gctx.Config().Unfurl.Cooldown
+	if cooldown <= 0 {
+		cooldown = time.Second * 10
+	}
+
+	// Rate limit per channel, so that link spam doesn't flood it with embeds
+	ok, err := gctx.Inst().Redis.RawClient().SetNX(gctx,
+		gctx.Inst().Redis.ComposeKey("compactdisc", "unfurl", msg.ChannelID).String(), "1", cooldown,
+	).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	embeds := []*discordgo.MessageEmbed{}
+
+	for _, link := range links {
+		var (
+			embed *discordgo.MessageEmbed
+			err   error
+		)
+
+		switch link.kind {
+		case "emotes":
+			embed, err = emoteEmbed(gctx, link.id)
+		case "users":
+			embed, err = userEmbed(gctx, link.id)
+		case "emote-sets":
+			embed, err = emoteSetEmbed(gctx, link.id)
+		}
+
+		if err != nil {
+			continue // the link may point to something which doesn't exist
+		}
+
+		embeds = append(embeds, embed)
+	}
+
+	if len(embeds) == 0 {
+		return
+	}
+
+	if _, err := s.ChannelMessageSendComplex(msg.ChannelID, &discordgo.MessageSend{
+		Embeds:          embeds,
+		Reference:       msg.Reference(),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Dismiss",
+						Style:    discordgo.SecondaryButton,
+						CustomID: UnfurlDismissPrefix + msg.Author.ID,
+					},
+				},
+			},
+		},
+	}); err != nil {
+		zap.S().Errorw("failed to unfurl links", "error", err)
+		return
+	}
+
+	// Hide discord's generic preview, which the unfurl replaces
+	_, _ = s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:      msg.ID,
+		Channel: msg.ChannelID,
+		Flags:   discordgo.MessageFlagsSuppressEmbeds,
+	})
+}
+
+// UnfurlDismiss removes an unfurl when the user who posted the link or a moderator asks for it.
+// It handles the buttons whose custom ID starts with UnfurlDismissPrefix
+func UnfurlDismiss(gctx global.Context) func(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+		if i.Member == nil {
+			return nil
+		}
+
+		authorID := strings.TrimPrefix(i.MessageComponentData().CustomID, UnfurlDismissPrefix)
+
+		if i.Member.User.ID != authorID && i.Member.Permissions&discordgo.PermissionManageMessages == 0 {
+			return fmt.Errorf("only the person who posted the link can dismiss this")
+		}
+
+		if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredMessageUpdate,
+		}); err != nil {
+			return err
+		}
+
+		return s.ChannelMessageDelete(i.ChannelID, i.Message.ID)
+	}
+}
+
+func emoteEmbed(gctx global.Context, id primitive.ObjectID) (*discordgo.MessageEmbed, error) {
+	emote, err := gctx.Inst().Query.Emotes(gctx, bson.M{"versions.id": id}).First()
+	if err != nil {
+		return nil, err
+	}
+
+	var version structures.EmoteVersion
+
+	for _, v := range emote.Versions {
+		if v.ID == id {
+			version = v
+		}
+	}
+
+	listed := "Unlisted"
+	if version.State.Listed {
+		listed = "Listed"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:     emote.Name,
+		URL:       fmt.Sprintf("%s/emotes/%s", gctx.Config().WebsiteURL, id.Hex()),
+		Color:     0x29B6F6,
+		Timestamp: version.CreatedAt.Format(time.RFC3339),
+		Image: &discordgo.MessageEmbedImage{
+			URL: fmt.Sprintf("https://%s/emote/%s/4x.webp", gctx.Config().CdnURL, id.Hex()),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Listing",
+				Value:  listed,
+				Inline: true,
+			},
+			{
+				Name:   "Channels",
+				Value:  fmt.Sprintf("%d", version.State.ChannelCount),
+				Inline: true,
+			},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "7TV Emote",
+		},
+	}
+
+	if owner := unfurlOwner(gctx, emote.Owner, emote.OwnerID); owner != nil {
+		embed.Author = owner
+	}
+
+	if len(emote.Tags) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Tags",
+			Value: transcript.Truncate(strings.Join(emote.Tags, ", "), 1024),
+		})
+	}
+
+	return embed, nil
+}
+
+func userEmbed(gctx global.Context, id primitive.ObjectID) (*discordgo.MessageEmbed, error) {
+	user, err := gctx.Inst().Query.Users(gctx, bson.M{"_id": id}).First()
+	if err != nil {
+		return nil, err
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s (%s)", user.DisplayName, user.Username),
+		URL:         user.WebURL(gctx.Config().WebsiteURL),
+		Description: transcript.Truncate(user.Biography, 4096),
+		Color:       int(user.GetHighestRole().Color),
+		Timestamp:   user.ID.Timestamp().Format(time.RFC3339),
+		Fields:      []*discordgo.MessageEmbedField{},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "7TV User",
+		},
+	}
+
+	if user.AvatarID != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{
+			URL: fmt.Sprintf("https://%s/pp/%s/%s", gctx.Config().CdnURL, user.ID.Hex(), user.AvatarID),
+		}
+	}
+
+	if len(user.Roles) > 0 {
+		roles := make([]string, len(user.Roles))
+		for i, rol := range user.Roles {
+			roles[i] = rol.Name
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Roles",
+			Value: transcript.Truncate(strings.Join(roles, ", "), 1024),
+		})
+	}
+
+	return embed, nil
+}
+
+func emoteSetEmbed(gctx global.Context, id primitive.ObjectID) (*discordgo.MessageEmbed, error) {
+	set, err := gctx.Inst().Query.EmoteSets(gctx, bson.M{"_id": id}).First()
+	if err != nil {
+		return nil, err
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: set.Name,
+		URL:   fmt.Sprintf("%s/emote-sets/%s", gctx.Config().WebsiteURL, id.Hex()),
+		Color: 0x29B6F6,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Emotes",
+				Value:  fmt.Sprintf("%d / %d", len(set.Emotes), set.Capacity),
+				Inline: true,
+			},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "7TV Emote Set",
+		},
+	}
+
+	if owner := unfurlOwner(gctx, set.Owner, set.OwnerID); owner != nil {
+		embed.Author = owner
+	}
+
+	if len(set.Emotes) > 0 {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{
+			URL: fmt.Sprintf("https://%s/emote/%s/4x.webp", gctx.Config().CdnURL, set.Emotes[0].ID.Hex()),
+		}
+	}
+
+	if len(set.Tags) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Tags",
+			Value: transcript.Truncate(strings.Join(set.Tags, ", "), 1024),
+		})
+	}
+
+	return embed, nil
+}
+
+// unfurlOwner returns the embed author for the owner of an emote or emote set, fetching it if it wasn't included
+func unfurlOwner(gctx global.Context, owner *structures.User, ownerID primitive.ObjectID) *discordgo.MessageEmbedAuthor {
+	if owner == nil {
+		if ownerID.IsZero() {
+			return nil
+		}
+
+		u, err := gctx.Inst().Query.Users(gctx, bson.M{"_id": ownerID}).First()
+		if err != nil {
+			return nil
+		}
+
+		owner = &u
+	}
+
+	return &discordgo.MessageEmbedAuthor{
+		Name: owner.DisplayName,
+		URL:  owner.WebURL(gctx.Config().WebsiteURL),
+	}
+}
@@ -4,13 +4,23 @@ import (
 	"github.com/seventv/api/data/query"
 	"github.com/seventv/common/mongo"
 	"github.com/seventv/common/redis"
+	"github.com/seventv/compactdisc/internal/blob"
 	"github.com/seventv/compactdisc/internal/discord"
+	"github.com/seventv/compactdisc/internal/leader"
+	"github.com/seventv/compactdisc/internal/messages"
+	"github.com/seventv/compactdisc/internal/modmail"
+	"github.com/seventv/compactdisc/internal/roles"
 )
 
 type Instances struct {
 	Mongo   mongo.Instance
 	Redis   redis.Instance
 	Discord discord.Instance
+	Blob    blob.Store
+	Leader  leader.Instance
 
-	Query *query.Query
+	Query    *query.Query
+	Roles    roles.Instance
+	Messages messages.Instance
+	Modmail  modmail.Instance
 }
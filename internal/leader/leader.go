@@ -0,0 +1,219 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	cdredis "github.com/seventv/common/redis"
+	"go.uber.org/zap"
+)
+
+// Instance elects a single replica as the leader, which is the only one allowed to handle gateway events
+type Instance interface {
+	// ID returns the identity of this replica
+	ID() string
+	// IsLeader returns whether this replica currently holds the leadership
+	IsLeader() bool
+	// OnElected registers a callback which runs every time this replica becomes the leader
+	OnElected(fn func())
+}
+
+type Options struct {
+	// Enabled toggles the election. When disabled the replica always considers itself the leader
+	Enabled bool
+	// Key is the redis key holding the lease
+	Key cdredis.Key
+	// ID is the identity of this replica
+	ID string
+	// Lease is how long the leadership is held without being renewed
+	Lease time.Duration
+}
+
+// renewScript extends the lease only if it is still held by the caller
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if it is still held by the caller
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type leaderInst struct {
+	rds     cdredis.Instance
+	key     string
+	channel string
+	id      string
+	lease   time.Duration
+
+	leading   int32
+	lastRenew time.Time
+
+	mx        sync.Mutex
+	callbacks []func()
+}
+
+func New(ctx context.Context, rds cdredis.Instance, opt Options) Instance {
+	if opt.Lease <= 0 {
+		opt.Lease = time.Second * 10
+	}
+
+	inst := &leaderInst{
+		rds:     rds,
+		key:     opt.Key.String(),
+		channel: opt.Key.String() + ":released",
+		id:      opt.ID,
+		lease:   opt.Lease,
+	}
+
+	if !opt.Enabled {
+		inst.leading = 1
+
+		return inst
+	}
+
+	go inst.run(ctx)
+
+	return inst
+}
+
+func (inst *leaderInst) ID() string {
+	return inst.id
+}
+
+func (inst *leaderInst) IsLeader() bool {
+	return atomic.LoadInt32(&inst.leading) == 1
+}
+
+func (inst *leaderInst) OnElected(fn func()) {
+	inst.mx.Lock()
+	inst.callbacks = append(inst.callbacks, fn)
+	inst.mx.Unlock()
+
+	if inst.IsLeader() {
+		go fn()
+	}
+}
+
+func (inst *leaderInst) run(ctx context.Context) {
+	cl := inst.rds.RawClient()
+
+	// Followers are notified when the leader steps down so that failover doesn't wait for the lease to expire
+	sub := cl.Subscribe(ctx, inst.channel)
+	defer sub.Close()
+
+	released := sub.Channel()
+
+	tick := time.NewTicker(inst.lease / 3)
+	defer tick.Stop()
+
+	inst.tick(ctx, cl)
+
+	for {
+		select {
+		case <-ctx.Done():
+			inst.release(cl)
+			return
+		case <-tick.C:
+		case <-released:
+		}
+
+		inst.tick(ctx, cl)
+	}
+}
+
+func (inst *leaderInst) tick(ctx context.Context, cl *redis.Client) {
+	z := zap.S().Named("leader").With("id", inst.id)
+
+	if inst.IsLeader() {
+		ok, err := renewScript.Run(ctx, cl, []string{inst.key}, inst.id, inst.lease.Milliseconds()).Int()
+		if err != nil {
+			z.Warnw("failed to renew leadership", "error", err)
+
+			// We can no longer be sure that nobody else took over
+			if time.Since(inst.lastRenew) >= inst.lease {
+				inst.demote()
+			}
+
+			return
+		}
+
+		if ok == 0 {
+			inst.demote()
+			return
+		}
+
+		inst.lastRenew = time.Now()
+
+		return
+	}
+
+	ok, err := cl.SetNX(ctx, inst.key, inst.id, inst.lease).Result()
+	if err != nil {
+		z.Warnw("failed to acquire leadership", "error", err)
+		return
+	}
+
+	if !ok {
+		return // someone else is the leader
+	}
+
+	inst.lastRenew = time.Now()
+	atomic.StoreInt32(&inst.leading, 1)
+
+	z.Info("elected as leader")
+
+	inst.mx.Lock()
+	callbacks := make([]func(), len(inst.callbacks))
+	copy(callbacks, inst.callbacks)
+	inst.mx.Unlock()
+
+	for _, fn := range callbacks {
+		go fn()
+	}
+}
+
+func (inst *leaderInst) demote() {
+	atomic.StoreInt32(&inst.leading, 0)
+
+	zap.S().Named("leader").Warnw("lost leadership", "id", inst.id)
+}
+
+// release gives up the leadership on shutdown and notifies the followers
+func (inst *leaderInst) release(cl *redis.Client) {
+	if !inst.IsLeader() {
+		return
+	}
+
+	atomic.StoreInt32(&inst.leading, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if _, err := releaseScript.Run(ctx, cl, []string{inst.key}, inst.id).Result(); err != nil {
+		zap.S().Named("leader").Errorw("failed to release leadership", "error", err)
+		return
+	}
+
+	_ = cl.Publish(ctx, inst.channel, inst.id).Err()
+}
+
+// Only wraps an event handler so that it only runs while this replica is the leader
+func Only[S any, E any](inst Instance, fn func(S, E)) func(S, E) {
+	return func(s S, e E) {
+		if !inst.IsLeader() {
+			return
+		}
+
+		fn(s, e)
+	}
+}
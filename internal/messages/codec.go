@@ -0,0 +1,176 @@
+package messages
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Payload formats, stored as the first byte of an encoded payload.
+// Payloads written before formats were introduced are raw json and start with '{'
+const (
+	formatPlain     byte = 0x01
+	formatDeflate   byte = 0x02
+	formatEncrypted byte = 0x03
+	// formatBound is encrypted like formatEncrypted, with the ciphertext also bound to the message it belongs to
+	formatBound byte = 0x04
+)
+
+var errUnknownFormat = errors.New("unknown payload format")
+
+// compactMessage is the subset of a message which is cached, with short keys to save memory
+type compactMessage struct {
+	ID          string              `json:"i"`
+	ChannelID   string              `json:"c"`
+	GuildID     string              `json:"g,omitempty"`
+	Content     string              `json:"t,omitempty"`
+	Timestamp   int64               `json:"ts"`
+	Edited      int64               `json:"e,omitempty"`
+	Author      *compactUser        `json:"a,omitempty"`
+	Attachments []compactAttachment `json:"f,omitempty"`
+}
+
+type compactUser struct {
+	ID            string `json:"i"`
+	Username      string `json:"u"`
+	Discriminator string `json:"d,omitempty"`
+	Avatar        string `json:"av,omitempty"`
+	Bot           bool   `json:"b,omitempty"`
+}
+
+type compactAttachment struct {
+	ID          string `json:"i"`
+	URL         string `json:"u"`
+	Filename    string `json:"n"`
+	ContentType string `json:"t,omitempty"`
+	Size        int    `json:"s,omitempty"`
+}
+
+func encodeMessage(msg *discordgo.Message) ([]byte, error) {
+	cm := compactMessage{
+		ID:        msg.ID,
+		ChannelID: msg.ChannelID,
+		GuildID:   msg.GuildID,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp.UnixMilli(),
+	}
+
+	if msg.EditedTimestamp != nil {
+		cm.Edited = msg.EditedTimestamp.UnixMilli()
+	}
+
+	if msg.Author != nil {
+		cm.Author = &compactUser{
+			ID:            msg.Author.ID,
+			Username:      msg.Author.Username,
+			Discriminator: msg.Author.Discriminator,
+			Avatar:        msg.Author.Avatar,
+			Bot:           msg.Author.Bot,
+		}
+	}
+
+	for _, a := range msg.Attachments {
+		cm.Attachments = append(cm.Attachments, compactAttachment{
+			ID:          a.ID,
+			URL:         a.URL,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+		})
+	}
+
+	return json.Marshal(cm)
+}
+
+func decodeMessage(b []byte, legacy bool) (*discordgo.Message, error) {
+	if legacy {
+		var msg *discordgo.Message
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return nil, err
+		}
+
+		return msg, nil
+	}
+
+	var cm compactMessage
+	if err := json.Unmarshal(b, &cm); err != nil {
+		return nil, err
+	}
+
+	msg := &discordgo.Message{
+		ID:          cm.ID,
+		ChannelID:   cm.ChannelID,
+		GuildID:     cm.GuildID,
+		Content:     cm.Content,
+		Timestamp:   time.UnixMilli(cm.Timestamp),
+		Attachments: make([]*discordgo.MessageAttachment, len(cm.Attachments)),
+	}
+
+	if cm.Edited != 0 {
+		t := time.UnixMilli(cm.Edited)
+		msg.EditedTimestamp = &t
+	}
+
+	if cm.Author != nil {
+		msg.Author = &discordgo.User{
+			ID:            cm.Author.ID,
+			Username:      cm.Author.Username,
+			Discriminator: cm.Author.Discriminator,
+			Avatar:        cm.Author.Avatar,
+			Bot:           cm.Author.Bot,
+		}
+	}
+
+	for i, a := range cm.Attachments {
+		msg.Attachments[i] = &discordgo.MessageAttachment{
+			ID:          a.ID,
+			URL:         a.URL,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+		}
+	}
+
+	return msg, nil
+}
+
+// pack prefixes a payload with its format, compressing it if requested
+func pack(b []byte, compress bool) []byte {
+	if !compress {
+		return append([]byte{formatPlain}, b...)
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteByte(formatDeflate)
+
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	_, _ = w.Write(b)
+	_ = w.Close()
+
+	return buf.Bytes()
+}
+
+// unpack returns the original payload of a packed one, and whether it is a legacy payload
+func unpack(data []byte) ([]byte, bool, error) {
+	if len(data) == 0 {
+		return nil, false, errUnknownFormat
+	}
+
+	switch data[0] {
+	case '{':
+		return data, true, nil
+	case formatPlain:
+		return data[1:], false, nil
+	case formatDeflate:
+		b, err := io.ReadAll(flate.NewReader(bytes.NewReader(data[1:])))
+
+		return b, false, err
+	}
+
+	return nil, false, errUnknownFormat
+}
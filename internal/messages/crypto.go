@@ -0,0 +1,134 @@
+package messages
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrDecrypt is returned when a cached payload cannot be decrypted, i.e because its key was removed from the key ring
+var ErrDecrypt = errors.New("failed to decrypt cached payload")
+
+// KeyRing encrypts payloads with AES-GCM using its active key, and decrypts them with any of its keys.
+// Keys are identified so that a new key can be activated while payloads encrypted with older keys remain readable
+type KeyRing struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewKeyRing creates a key ring from base64 encoded 256-bit keys, keyed by their ID
+func NewKeyRing(active string, keys map[string]string) (*KeyRing, error) {
+	kr := &KeyRing{
+		active: active,
+		keys:   make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for id, k := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("key id %q must be between 1 and 255 characters", id)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+
+		if len(raw) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(raw))
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		kr.keys[id] = aead
+	}
+
+	if _, ok := kr.keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the key ring", active)
+	}
+
+	return kr, nil
+}
+
+// seal encrypts a payload with the active key, binding it to what it is stored as, i.e a message ID.
+// The result is laid out as the format, the key id's length, the key id, the nonce and the ciphertext
+func (kr *KeyRing) seal(b []byte, subject string) ([]byte, error) {
+	aead := kr.keys[kr.active]
+
+	header := make([]byte, 0, 2+len(kr.active)+aead.NonceSize())
+	header = append(header, formatBound, byte(len(kr.active)))
+	header = append(header, kr.active...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header = append(header, nonce...)
+
+	// The key id and the subject are authenticated so that neither the key nor the payload can be swapped
+	return aead.Seal(header, nonce, b, additionalData(formatBound, kr.active, subject)), nil
+}
+
+// open decrypts a sealed payload, which must have been sealed for the same subject.
+// Payloads encrypted before they were bound to a subject are still read, until they are migrated
+func (kr *KeyRing) open(data []byte, subject string) ([]byte, error) {
+	if len(data) < 2 || (data[0] != formatEncrypted && data[0] != formatBound) {
+		return nil, ErrDecrypt
+	}
+
+	idLen := int(data[1])
+	if len(data) < 2+idLen {
+		return nil, ErrDecrypt
+	}
+
+	id := string(data[2 : 2+idLen])
+
+	aead, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrDecrypt, id)
+	}
+
+	rest := data[2+idLen:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	b, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], additionalData(data[0], id, subject))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecrypt, err.Error())
+	}
+
+	return b, nil
+}
+
+// isActive returns whether a payload is bound to its subject and encrypted with the active key
+func (kr *KeyRing) isActive(data []byte) bool {
+	return len(data) >= 2+len(kr.active) && data[0] == formatBound &&
+		int(data[1]) == len(kr.active) && string(data[2:2+len(kr.active)]) == kr.active
+}
+
+// additionalData returns the data authenticated along with a payload of a format
+func additionalData(format byte, keyID string, subject string) []byte {
+	if format == formatEncrypted {
+		return []byte(keyID)
+	}
+
+	// The key id's length is at most 255, so its end is unambiguous
+	return append([]byte{byte(len(keyID))}, keyID+subject...)
+}
+
+// isEncrypted returns whether a payload is encrypted
+func isEncrypted(data []byte) bool {
+	return len(data) > 0 && (data[0] == formatEncrypted || data[0] == formatBound)
+}
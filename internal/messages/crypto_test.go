@@ -0,0 +1,72 @@
+package messages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newTestKeyRing(t *testing.T) *KeyRing {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := NewKeyRing("a", map[string]string{"a": base64.StdEncoding.EncodeToString(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kr
+}
+
+func TestSealIsBoundToSubject(t *testing.T) {
+	kr := newTestKeyRing(t)
+
+	data, err := kr.seal([]byte("hello"), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := kr.open(data, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, []byte("hello")) {
+		t.Fatalf("expected the sealed payload, got %q", b)
+	}
+
+	if _, err := kr.open(data, "2"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected a payload moved to another message to fail decryption, got %v", err)
+	}
+
+	if !kr.isActive(data) {
+		t.Fatal("expected a bound payload to be up to date")
+	}
+}
+
+func TestOpenUnboundPayload(t *testing.T) {
+	kr := newTestKeyRing(t)
+	aead := kr.keys["a"]
+
+	// A payload encrypted before payloads were bound to their message
+	nonce := make([]byte, aead.NonceSize())
+	data := append([]byte{formatEncrypted, 1, 'a'}, nonce...)
+	data = aead.Seal(data, nonce, []byte("hello"), []byte("a"))
+
+	b, err := kr.open(data, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, []byte("hello")) {
+		t.Fatalf("expected the sealed payload, got %q", b)
+	}
+
+	if kr.isActive(data) {
+		t.Fatal("expected an unbound payload to be migrated")
+	}
+}
@@ -0,0 +1,235 @@
+package messages
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryBackend struct {
+	mx       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	authors  map[string]map[string]time.Time
+}
+
+type memoryEntry struct {
+	id        string
+	data      []byte
+	revisions [][]byte
+	expireAt  time.Time
+}
+
+// NewMemoryBackend creates a backend storing up to capacity messages in memory, evicting the least recently used
+func NewMemoryBackend(capacity int) Backend {
+	if capacity <= 0 {
+		capacity = 100000
+	}
+
+	return &memoryBackend{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		authors:  make(map[string]map[string]time.Time),
+	}
+}
+
+// get returns a live entry and marks it as recently used. The lock must be held
+func (b *memoryBackend) get(id string) *memoryEntry {
+	el, ok := b.entries[id]
+	if !ok {
+		return nil
+	}
+
+	e := el.Value.(*memoryEntry) //nolint:forcetypeassert
+
+	if time.Now().After(e.expireAt) {
+		b.order.Remove(el)
+		delete(b.entries, id)
+
+		return nil
+	}
+
+	b.order.MoveToFront(el)
+
+	return e
+}
+
+func (b *memoryBackend) Get(ctx context.Context, id string) ([]byte, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	e := b.get(id)
+	if e == nil || e.data == nil {
+		return nil, ErrNotFound
+	}
+
+	return e.data, nil
+}
+
+func (b *memoryBackend) GetMany(ctx context.Context, ids []string) (map[string][]byte, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	result := make(map[string][]byte, len(ids))
+
+	for _, id := range ids {
+		if e := b.get(id); e != nil && e.data != nil {
+			result[id] = e.data
+		}
+	}
+
+	return result, nil
+}
+
+func (b *memoryBackend) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	e := b.upsert(id)
+	e.data = data
+	e.expireAt = time.Now().Add(ttl)
+
+	return nil
+}
+
+func (b *memoryBackend) AddRevision(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	e := b.upsert(id)
+	e.revisions = append(e.revisions, data)
+	e.expireAt = time.Now().Add(ttl)
+
+	return nil
+}
+
+func (b *memoryBackend) Revisions(ctx context.Context, id string) ([][]byte, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	e := b.get(id)
+	if e == nil {
+		return [][]byte{}, nil
+	}
+
+	return e.revisions, nil
+}
+
+// upsert returns the entry of a message, creating it and evicting the oldest entries if needed. The lock must be held
+func (b *memoryBackend) upsert(id string) *memoryEntry {
+	if e := b.get(id); e != nil {
+		return e
+	}
+
+	e := &memoryEntry{id: id}
+	b.entries[id] = b.order.PushFront(e)
+
+	for b.order.Len() > b.capacity {
+		el := b.order.Back()
+		b.order.Remove(el)
+		delete(b.entries, el.Value.(*memoryEntry).id) //nolint:forcetypeassert
+	}
+
+	return e
+}
+
+func (b *memoryBackend) Rewrite(ctx context.Context, fn func(id string, revision bool, data []byte) ([]byte, error)) (int, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	count := 0
+
+	for _, el := range b.entries {
+		e := el.Value.(*memoryEntry) //nolint:forcetypeassert
+
+		if e.data != nil {
+			if result, err := fn(e.id, false, e.data); err == nil && result != nil {
+				e.data = result
+				count++
+			}
+		}
+
+		for i, rev := range e.revisions {
+			if result, err := fn(e.id, true, rev); err == nil && result != nil {
+				e.revisions[i] = result
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+func (b *memoryBackend) IndexAuthor(ctx context.Context, authorID string, id string, ts time.Time, ttl time.Duration) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	ids, ok := b.authors[authorID]
+	if !ok {
+		ids = make(map[string]time.Time)
+		b.authors[authorID] = ids
+	}
+
+	ids[id] = ts
+
+	return nil
+}
+
+func (b *memoryBackend) ByAuthor(ctx context.Context, authorID string, since time.Time) ([]string, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	type indexed struct {
+		id string
+		ts time.Time
+	}
+
+	found := []indexed{}
+
+	for id, ts := range b.authors[authorID] {
+		if _, ok := b.entries[id]; !ok {
+			delete(b.authors[authorID], id) // evicted or expired
+
+			continue
+		}
+
+		if !ts.Before(since) {
+			found = append(found, indexed{id, ts})
+		}
+	}
+
+	if len(b.authors[authorID]) == 0 {
+		delete(b.authors, authorID)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].ts.Before(found[j].ts)
+	})
+
+	result := make([]string, len(found))
+	for i, f := range found {
+		result[i] = f.id
+	}
+
+	return result, nil
+}
+
+func (b *memoryBackend) Delete(ctx context.Context, authorID string, ids []string) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for _, id := range ids {
+		if el, ok := b.entries[id]; ok {
+			b.order.Remove(el)
+			delete(b.entries, id)
+		}
+
+		delete(b.authors[authorID], id)
+	}
+
+	return nil
+}
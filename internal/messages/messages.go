@@ -0,0 +1,464 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/utils"
+)
+
+// ErrNotFound is returned when a message is not in the cache
+var ErrNotFound = errors.New("message not cached")
+
+// Instance is a cache of the messages sent in the guild
+type Instance interface {
+	// Get returns a cached message
+	Get(ctx context.Context, id string) (*discordgo.Message, error)
+	// GetMany returns the cached messages among the given IDs, skipping those which are not cached
+	GetMany(ctx context.Context, ids []string) ([]*discordgo.Message, error)
+	// Set stores a message in the cache, if its channel's policy allows it
+	Set(ctx context.Context, msg *discordgo.Message) error
+	// AddRevision appends the previous version of an edited message to its history
+	AddRevision(ctx context.Context, msg *discordgo.Message, rev Revision) error
+	// Revisions returns the previous versions of a message, oldest first
+	Revisions(ctx context.Context, id string) ([]Revision, error)
+	// ByAuthor returns the cached messages of an author sent since a time, oldest first
+	ByAuthor(ctx context.Context, authorID string, since time.Time) ([]*discordgo.Message, error)
+	// Delete removes messages, their revisions and their index entries from the cache
+	Delete(ctx context.Context, msgs ...*discordgo.Message) error
+	// Seal encodes a payload other than a message like cached messages are, encrypting it for a subject when the cache is encrypted
+	Seal(b []byte, subject string) ([]byte, error)
+	// Open decodes a payload sealed for a subject
+	Open(data []byte, subject string) ([]byte, error)
+	// IndexAuthors adds every cached message to the index of its author, for messages cached before the index existed
+	IndexAuthors(ctx context.Context) (int, error)
+	// Migrate re-encodes every cached payload with the current options, i.e to encrypt payloads
+	// written before encryption was enabled or with a key which is being rotated out
+	Migrate(ctx context.Context) (int, error)
+}
+
+// Backend stores encoded messages
+type Backend interface {
+	Get(ctx context.Context, id string) ([]byte, error)
+	// GetMany returns the stored payloads among the given IDs, keyed by ID
+	GetMany(ctx context.Context, ids []string) (map[string][]byte, error)
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	AddRevision(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	Revisions(ctx context.Context, id string) ([][]byte, error)
+	// IndexAuthor records that a message was sent by an author
+	IndexAuthor(ctx context.Context, authorID string, id string, ts time.Time, ttl time.Duration) error
+	// ByAuthor returns the IDs of the messages indexed for an author since a time, oldest first
+	ByAuthor(ctx context.Context, authorID string, since time.Time) ([]string, error)
+	// Delete removes messages, their revisions and their index entries
+	Delete(ctx context.Context, authorID string, ids []string) error
+	// Rewrite replaces every stored payload, including revisions, with the result of fn, which is given the ID of their message.
+	// Payloads for which fn returns nil are left as is, and so are payloads which changed while fn ran
+	Rewrite(ctx context.Context, fn func(id string, revision bool, data []byte) ([]byte, error)) (int, error)
+}
+
+// Policy decides which channels are cached and for how long
+type Policy struct {
+	// Retention is how long messages are kept by default
+	Retention time.Duration
+	// Include restricts caching to these channels when it is not empty
+	Include []string
+	// Exclude prevents these channels from being cached
+	Exclude []string
+	// ChannelRetention overrides the retention of specific channels
+	ChannelRetention map[string]time.Duration
+	// ParentOf resolves the channel a thread belongs to, which the rules of the thread's channel apply to as well.
+	// It returns an empty string for channels which aren't threads
+	ParentOf func(channelID string) string
+}
+
+// channels returns a channel along with its parent, if it is a thread
+func (p Policy) channels(channelID string) []string {
+	if p.ParentOf == nil {
+		return []string{channelID}
+	}
+
+	if parentID := p.ParentOf(channelID); parentID != "" {
+		return []string{channelID, parentID}
+	}
+
+	return []string{channelID}
+}
+
+// Cached returns whether messages of a channel are cached. Threads of an excluded channel are excluded,
+// and threads of an included channel are included
+func (p Policy) Cached(channelID string) bool {
+	channels := p.channels(channelID)
+
+	for _, id := range channels {
+		if utils.Contains(p.Exclude, id) {
+			return false
+		}
+	}
+
+	if len(p.Include) == 0 {
+		return true
+	}
+
+	for _, id := range channels {
+		if utils.Contains(p.Include, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetentionOf returns how long messages of a channel are kept. Threads fall back to the retention of their channel
+func (p Policy) RetentionOf(channelID string) time.Duration {
+	for _, id := range p.channels(channelID) {
+		if d, ok := p.ChannelRetention[id]; ok && d > 0 {
+			return d
+		}
+	}
+
+	if p.Retention > 0 {
+		return p.Retention
+	}
+
+	return time.Hour * 72
+}
+
+type Options struct {
+	Policy Policy
+	// Compress compresses the payloads
+	Compress bool
+	// Keys encrypts the payloads when set
+	Keys *KeyRing
+}
+
+type messagesInst struct {
+	backend Backend
+	opt     Options
+}
+
+func New(backend Backend, opt Options) Instance {
+	return &messagesInst{
+		backend: backend,
+		opt:     opt,
+	}
+}
+
+func (inst *messagesInst) Get(ctx context.Context, id string) (*discordgo.Message, error) {
+	data, err := inst.backend.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	b, legacy, err := inst.decode(data, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMessage(b, legacy)
+}
+
+func (inst *messagesInst) GetMany(ctx context.Context, ids []string) ([]*discordgo.Message, error) {
+	if len(ids) == 0 {
+		return []*discordgo.Message{}, nil
+	}
+
+	items, err := inst.backend.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*discordgo.Message, 0, len(items))
+
+	for _, id := range ids {
+		data, ok := items[id]
+		if !ok {
+			continue
+		}
+
+		b, legacy, err := inst.decode(data, id)
+		if err != nil {
+			continue
+		}
+
+		msg, err := decodeMessage(b, legacy)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, msg)
+	}
+
+	return result, nil
+}
+
+func (inst *messagesInst) Set(ctx context.Context, msg *discordgo.Message) error {
+	if !inst.opt.Policy.Cached(msg.ChannelID) {
+		return nil
+	}
+
+	j, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	data, err := inst.encode(j, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	ttl := inst.opt.Policy.RetentionOf(msg.ChannelID)
+
+	if err := inst.backend.Set(ctx, msg.ID, data, ttl); err != nil {
+		return err
+	}
+
+	if msg.Author == nil {
+		return nil
+	}
+
+	return inst.backend.IndexAuthor(ctx, msg.Author.ID, msg.ID, msg.Timestamp, ttl)
+}
+
+func (inst *messagesInst) AddRevision(ctx context.Context, msg *discordgo.Message, rev Revision) error {
+	if !inst.opt.Policy.Cached(msg.ChannelID) {
+		return nil
+	}
+
+	j, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	data, err := inst.encode(j, revisionSubject(msg.ID))
+	if err != nil {
+		return err
+	}
+
+	return inst.backend.AddRevision(ctx, msg.ID, data, inst.opt.Policy.RetentionOf(msg.ChannelID))
+}
+
+func (inst *messagesInst) Revisions(ctx context.Context, id string) ([]Revision, error) {
+	items, err := inst.backend.Revisions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Revision, 0, len(items))
+
+	for _, item := range items {
+		b, _, err := inst.decode(item, revisionSubject(id))
+		if err != nil {
+			continue
+		}
+
+		var rev Revision
+		if err := json.Unmarshal(b, &rev); err != nil {
+			continue
+		}
+
+		result = append(result, rev)
+	}
+
+	return result, nil
+}
+
+func (inst *messagesInst) ByAuthor(ctx context.Context, authorID string, since time.Time) ([]*discordgo.Message, error) {
+	ids, err := inst.backend.ByAuthor(ctx, authorID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := inst.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// The backend returns them in order, but expired messages are skipped
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp.Before(msgs[j].Timestamp)
+	})
+
+	return msgs, nil
+}
+
+func (inst *messagesInst) Delete(ctx context.Context, msgs ...*discordgo.Message) error {
+	byAuthor := map[string][]string{}
+
+	for _, msg := range msgs {
+		authorID := ""
+		if msg.Author != nil {
+			authorID = msg.Author.ID
+		}
+
+		byAuthor[authorID] = append(byAuthor[authorID], msg.ID)
+	}
+
+	for authorID, ids := range byAuthor {
+		if err := inst.backend.Delete(ctx, authorID, ids); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (inst *messagesInst) Seal(b []byte, subject string) ([]byte, error) {
+	return inst.encode(b, subject)
+}
+
+func (inst *messagesInst) Open(data []byte, subject string) ([]byte, error) {
+	b, _, err := inst.decode(data, subject)
+
+	return b, err
+}
+
+func (inst *messagesInst) IndexAuthors(ctx context.Context) (int, error) {
+	type entry struct {
+		authorID string
+		id       string
+		ts       time.Time
+		ttl      time.Duration
+	}
+
+	// Messages are indexed once the rewrite is over, as backends may hold a lock while rewriting
+	entries := []entry{}
+
+	_, err := inst.backend.Rewrite(ctx, func(id string, revision bool, data []byte) ([]byte, error) {
+		if revision {
+			return nil, nil
+		}
+
+		b, legacy, err := inst.decode(data, id)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := decodeMessage(b, legacy)
+		if err != nil || msg.Author == nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry{msg.Author.ID, id, msg.Timestamp, inst.opt.Policy.RetentionOf(msg.ChannelID)})
+
+		return nil, nil // the payload itself is left as is
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, e := range entries {
+		if err := inst.backend.IndexAuthor(ctx, e.authorID, e.id, e.ts, e.ttl); err != nil {
+			return i, err
+		}
+	}
+
+	return len(entries), nil
+}
+
+func (inst *messagesInst) Migrate(ctx context.Context) (int, error) {
+	return inst.backend.Rewrite(ctx, func(id string, revision bool, data []byte) ([]byte, error) {
+		if inst.opt.Keys != nil && inst.opt.Keys.isActive(data) {
+			return nil, nil // already up to date
+		}
+
+		subject := id
+		if revision {
+			subject = revisionSubject(id)
+		}
+
+		b, legacy, err := inst.decode(data, subject)
+		if err != nil {
+			return nil, err
+		}
+
+		if legacy { // convert the full message to the compact format
+			msg, err := decodeMessage(b, true)
+			if err != nil {
+				return nil, err
+			}
+
+			if msg.ID != "" {
+				if b, err = encodeMessage(msg); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return inst.encode(b, subject)
+	})
+}
+
+// encode packs a payload, then encrypts it for its subject if a key ring is configured
+func (inst *messagesInst) encode(b []byte, subject string) ([]byte, error) {
+	data := pack(b, inst.opt.Compress)
+	if inst.opt.Keys == nil {
+		return data, nil
+	}
+
+	return inst.opt.Keys.seal(data, subject)
+}
+
+// decode decrypts a payload of a subject if needed, then unpacks it
+func (inst *messagesInst) decode(data []byte, subject string) ([]byte, bool, error) {
+	if isEncrypted(data) {
+		if inst.opt.Keys == nil {
+			return nil, false, fmt.Errorf("%w: no key ring is configured", ErrDecrypt)
+		}
+
+		var err error
+		if data, err = inst.opt.Keys.open(data, subject); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return unpack(data)
+}
+
+// revisionSubject is what the revisions of a message are encrypted for, so they can't pass as the message itself
+func revisionSubject(id string) string {
+	return id + "/revision"
+}
+
+// Revision is a previous version of an edited message
+type Revision struct {
+	Content     string                         `json:"content"`
+	Attachments []*discordgo.MessageAttachment `json:"attachments"`
+	// Timestamp is when this version of the message was created or edited
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RevisionOf returns the current version of a message as a revision
+func RevisionOf(msg *discordgo.Message) Revision {
+	ts := msg.Timestamp
+	if msg.EditedTimestamp != nil {
+		ts = *msg.EditedTimestamp
+	}
+
+	return Revision{
+		Content:     msg.Content,
+		Attachments: msg.Attachments,
+		Timestamp:   ts,
+	}
+}
+
+// ThreadParent resolves the parent of threads from the state of a session, for Policy.ParentOf
+func ThreadParent(s *discordgo.Session) func(channelID string) string {
+	return func(channelID string) string {
+		ch, err := s.State.Channel(channelID)
+		if err != nil || !ch.IsThread() {
+			return ""
+		}
+
+		return ch.ParentID
+	}
+}
+
+// JumpURL returns a link to a message
+func JumpURL(msg *discordgo.Message) string {
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", msg.GuildID, msg.ChannelID, msg.ID)
+}
@@ -0,0 +1,219 @@
+package messages
+
+import (
+	"context"
+	"time"
+
+	"github.com/seventv/common/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoBackend struct {
+	coll *mongodriver.Collection
+}
+
+type mongoMessage struct {
+	ID        string    `bson:"_id"`
+	Data      []byte    `bson:"data,omitempty"`
+	Revisions [][]byte  `bson:"revisions,omitempty"`
+	AuthorID  string    `bson:"author_id,omitempty"`
+	Timestamp time.Time `bson:"ts,omitempty"`
+	ExpireAt  time.Time `bson:"expire_at"`
+}
+
+// NewMongoBackend creates a backend storing messages in a mongo collection, expired by a TTL index
+func NewMongoBackend(ctx context.Context, mg mongo.Instance, collection string) (Backend, error) {
+	coll := mg.Collection(mongo.CollectionName(collection))
+
+	if _, err := coll.Indexes().CreateMany(ctx, []mongodriver.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expire_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys: bson.D{{Key: "author_id", Value: 1}, {Key: "ts", Value: 1}},
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &mongoBackend{
+		coll: coll,
+	}, nil
+}
+
+func (b *mongoBackend) Get(ctx context.Context, id string) ([]byte, error) {
+	var doc mongoMessage
+	if err := b.coll.FindOne(ctx, bson.M{"_id": id, "expire_at": bson.M{"$gt": time.Now()}}).Decode(&doc); err != nil {
+		if err == mongodriver.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	if doc.Data == nil {
+		return nil, ErrNotFound
+	}
+
+	return doc.Data, nil
+}
+
+func (b *mongoBackend) GetMany(ctx context.Context, ids []string) (map[string][]byte, error) {
+	cur, err := b.coll.Find(ctx, bson.M{
+		"_id":       bson.M{"$in": ids},
+		"data":      bson.M{"$exists": true},
+		"expire_at": bson.M{"$gt": time.Now()},
+	}, options.Find().SetProjection(bson.M{"revisions": 0}))
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []mongoMessage{}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte, len(docs))
+	for _, doc := range docs {
+		result[doc.ID] = doc.Data
+	}
+
+	return result, nil
+}
+
+func (b *mongoBackend) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	_, err := b.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{
+			"data":      data,
+			"expire_at": time.Now().Add(ttl),
+		},
+	}, options.Update().SetUpsert(true))
+
+	return err
+}
+
+func (b *mongoBackend) AddRevision(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	_, err := b.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$push": bson.M{"revisions": data},
+		"$set":  bson.M{"expire_at": time.Now().Add(ttl)},
+	}, options.Update().SetUpsert(true))
+
+	return err
+}
+
+func (b *mongoBackend) Revisions(ctx context.Context, id string) ([][]byte, error) {
+	var doc mongoMessage
+	if err := b.coll.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"revisions": 1})).Decode(&doc); err != nil {
+		if err == mongodriver.ErrNoDocuments {
+			return [][]byte{}, nil
+		}
+
+		return nil, err
+	}
+
+	return doc.Revisions, nil
+}
+
+func (b *mongoBackend) Rewrite(ctx context.Context, fn func(id string, revision bool, data []byte) ([]byte, error)) (int, error) {
+	cur, err := b.coll.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+
+	defer cur.Close(ctx)
+
+	count := 0
+
+	for cur.Next(ctx) {
+		var doc mongoMessage
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+
+		set := bson.M{}
+		// The document is only updated if what was rewritten hasn't changed meanwhile, i.e because the message was edited
+		filter := bson.M{"_id": doc.ID}
+
+		if doc.Data != nil {
+			if result, err := fn(doc.ID, false, doc.Data); err == nil && result != nil {
+				set["data"] = result
+				filter["data"] = doc.Data
+			}
+		}
+
+		revisions := make([][]byte, len(doc.Revisions))
+		changed := false
+
+		for i, rev := range doc.Revisions {
+			revisions[i] = rev
+
+			if result, err := fn(doc.ID, true, rev); err == nil && result != nil {
+				revisions[i] = result
+				changed = true
+			}
+		}
+
+		if changed {
+			set["revisions"] = revisions
+			filter["revisions"] = doc.Revisions
+		}
+
+		if len(set) == 0 {
+			continue
+		}
+
+		res, err := b.coll.UpdateOne(ctx, filter, bson.M{"$set": set})
+		if err != nil {
+			return count, err
+		}
+
+		if res.ModifiedCount > 0 {
+			count++
+		}
+	}
+
+	return count, cur.Err()
+}
+
+func (b *mongoBackend) IndexAuthor(ctx context.Context, authorID string, id string, ts time.Time, ttl time.Duration) error {
+	_, err := b.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{
+			"author_id": authorID,
+			"ts":        ts,
+		},
+	}, options.Update().SetUpsert(true))
+
+	return err
+}
+
+func (b *mongoBackend) ByAuthor(ctx context.Context, authorID string, since time.Time) ([]string, error) {
+	cur, err := b.coll.Find(ctx, bson.M{
+		"author_id": authorID,
+		"ts":        bson.M{"$gte": since},
+		"expire_at": bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.M{"ts": 1}).SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []mongoMessage{}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(docs))
+	for i, doc := range docs {
+		result[i] = doc.ID
+	}
+
+	return result, nil
+}
+
+func (b *mongoBackend) Delete(ctx context.Context, authorID string, ids []string) error {
+	_, err := b.coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+
+	return err
+}
@@ -0,0 +1,258 @@
+package messages
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	cdredis "github.com/seventv/common/redis"
+	"go.uber.org/zap"
+)
+
+// replaceScript sets a value, keeping its TTL, only if it is still the one which was read
+var replaceScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+end
+return 0
+`)
+
+// replaceItemScript sets an item of a list only if it is still the one which was read
+var replaceItemScript = redis.NewScript(`
+if redis.call("LINDEX", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("LSET", KEYS[1], ARGV[1], ARGV[3])
+end
+return 0
+`)
+
+// indexAuthorScript adds a message to the index of its author, ordered by timestamp, and records when its entry expires.
+// Entries are only dropped once their own message has expired, and the index keys are kept for as long as their longest lived entry
+var indexAuthorScript = redis.NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+
+local expired = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[4])
+for i = 1, #expired, 500 do
+	local batch = {unpack(expired, i, math.min(i + 499, #expired))}
+	redis.call("ZREM", KEYS[1], unpack(batch))
+	redis.call("ZREM", KEYS[2], unpack(batch))
+end
+
+local ttl = tonumber(ARGV[5])
+for _, key in ipairs(KEYS) do
+	local current = redis.call("PTTL", key)
+	if current == -1 or (current >= 0 and current < ttl) then
+		redis.call("PEXPIRE", key, ttl)
+	end
+end
+return 1
+`)
+
+type redisBackend struct {
+	rds cdredis.Instance
+}
+
+// NewRedisBackend creates a backend storing messages in redis
+func NewRedisBackend(rds cdredis.Instance) Backend {
+	return &redisBackend{
+		rds: rds,
+	}
+}
+
+func (b *redisBackend) messageKey(id string) string {
+	return b.rds.ComposeKey("compactdisc", "cache", "message", id).String()
+}
+
+func (b *redisBackend) revisionsKey(id string) string {
+	return b.rds.ComposeKey("compactdisc", "cache", "message", id, "revisions").String()
+}
+
+func (b *redisBackend) Get(ctx context.Context, id string) ([]byte, error) {
+	data, err := b.rds.RawClient().Get(ctx, b.messageKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+func (b *redisBackend) GetMany(ctx context.Context, ids []string) (map[string][]byte, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = b.messageKey(id)
+	}
+
+	values, err := b.rds.RawClient().MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte, len(values))
+
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			result[ids[i]] = []byte(s)
+		}
+	}
+
+	return result, nil
+}
+
+func (b *redisBackend) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return b.rds.RawClient().Set(ctx, b.messageKey(id), data, ttl).Err()
+}
+
+func (b *redisBackend) AddRevision(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	key := b.revisionsKey(id)
+
+	_, err := b.rds.RawClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		pipe.Expire(ctx, key, ttl)
+
+		return nil
+	})
+
+	return err
+}
+
+func (b *redisBackend) Revisions(ctx context.Context, id string) ([][]byte, error) {
+	items, err := b.rds.RawClient().LRange(ctx, b.revisionsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, len(items))
+	for i, item := range items {
+		result[i] = []byte(item)
+	}
+
+	return result, nil
+}
+
+func (b *redisBackend) Rewrite(ctx context.Context, fn func(id string, revision bool, data []byte) ([]byte, error)) (int, error) {
+	cl := b.rds.RawClient()
+	count := 0
+
+	iter := cl.Scan(ctx, 0, b.messageKey("*"), 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		var err error
+		if strings.HasSuffix(key, ":revisions") {
+			id := key[strings.LastIndex(strings.TrimSuffix(key, ":revisions"), ":")+1 : len(key)-len(":revisions")]
+			err = b.rewriteList(ctx, key, func(data []byte) ([]byte, error) {
+				return fn(id, true, data)
+			})
+		} else {
+			id := key[strings.LastIndex(key, ":")+1:]
+			err = b.rewriteValue(ctx, key, func(data []byte) ([]byte, error) {
+				return fn(id, false, data)
+			})
+		}
+
+		if err != nil {
+			zap.S().Named("messages").Warnw("failed to rewrite cached payload", "key", key, "error", err)
+			continue
+		}
+
+		count++
+	}
+
+	return count, iter.Err()
+}
+
+func (b *redisBackend) rewriteValue(ctx context.Context, key string, fn func(data []byte) ([]byte, error)) error {
+	data, err := b.rds.RawClient().Get(ctx, key).Bytes()
+	if err != nil {
+		return err
+	}
+
+	result, err := fn(data)
+	if err != nil || result == nil {
+		return err
+	}
+
+	// The message may have been edited or deleted meanwhile, in which case it is left as is
+	return replaceScript.Run(ctx, b.rds.RawClient(), []string{key}, data, result).Err()
+}
+
+func (b *redisBackend) rewriteList(ctx context.Context, key string, fn func(data []byte) ([]byte, error)) error {
+	cl := b.rds.RawClient()
+
+	items, err := cl.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	for i, item := range items {
+		result, err := fn([]byte(item))
+		if err != nil {
+			return err
+		}
+
+		if result != nil {
+			if err := replaceItemScript.Run(ctx, cl, []string{key}, i, item, result).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *redisBackend) authorKey(authorID string) string {
+	return b.rds.ComposeKey("compactdisc", "cache", "author", authorID).String()
+}
+
+// authorExpiriesKey holds when each entry of an author's index expires, as messages are kept for as long as their channel's retention
+func (b *redisBackend) authorExpiriesKey(authorID string) string {
+	return b.rds.ComposeKey("compactdisc", "cache", "author-expiries", authorID).String()
+}
+
+func (b *redisBackend) IndexAuthor(ctx context.Context, authorID string, id string, ts time.Time, ttl time.Duration) error {
+	now := time.Now()
+
+	return indexAuthorScript.Run(ctx, b.rds.RawClient(), []string{b.authorKey(authorID), b.authorExpiriesKey(authorID)},
+		id,
+		ts.UnixMilli(),
+		now.Add(ttl).UnixMilli(),
+		now.UnixMilli(),
+		ttl.Milliseconds(),
+	).Err()
+}
+
+func (b *redisBackend) ByAuthor(ctx context.Context, authorID string, since time.Time) ([]string, error) {
+	return b.rds.RawClient().ZRangeByScore(ctx, b.authorKey(authorID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+func (b *redisBackend) Delete(ctx context.Context, authorID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(ids)*2)
+	members := make([]interface{}, len(ids))
+
+	for i, id := range ids {
+		keys = append(keys, b.messageKey(id), b.revisionsKey(id))
+		members[i] = id
+	}
+
+	_, err := b.rds.RawClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+
+		if authorID != "" {
+			pipe.ZRem(ctx, b.authorKey(authorID), members...)
+			pipe.ZRem(ctx, b.authorExpiriesKey(authorID), members...)
+		}
+
+		return nil
+	})
+
+	return err
+}
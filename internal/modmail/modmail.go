@@ -0,0 +1,296 @@
+package modmail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/api/data/query"
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc/internal/leader"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	collectionConversations mongo.CollectionName = "compactdisc_modmail"
+	collectionBlocks        mongo.CollectionName = "compactdisc_modmail_blocks"
+)
+
+var (
+	// ErrNotFound is returned when a channel is not a modmail thread
+	ErrNotFound = errors.New("this is not a modmail thread")
+	// ErrAlreadyOpen is returned when reopening a conversation while the user has another one open
+	ErrAlreadyOpen = errors.New("the user already has an open conversation")
+)
+
+// Instance relays direct messages sent to the bot into threads of a staff channel, and staff replies back
+type Instance interface {
+	// ByThread returns the conversation relayed in a thread
+	ByThread(ctx context.Context, threadID string) (*Conversation, error)
+	// Close ends a conversation, archives its thread and posts its transcript
+	Close(ctx context.Context, conv *Conversation, closedBy *discordgo.User) error
+	// Reopen resumes a closed conversation in its thread
+	Reopen(ctx context.Context, conv *Conversation) error
+	// Block prevents a user from opening conversations
+	Block(ctx context.Context, userID string, blockedBy *discordgo.User) error
+	// Unblock allows a blocked user to open conversations again
+	Unblock(ctx context.Context, userID string) error
+	// Blocked returns whether a user is blocked
+	Blocked(ctx context.Context, userID string) (bool, error)
+	// Purge deletes the conversations of a user, returning how many were deleted. A block is kept, under a hash of the user ID
+	Purge(ctx context.Context, userID string) (int, error)
+}
+
+type Status string
+
+const (
+	StatusOpen   Status = "open"
+	StatusClosed Status = "closed"
+)
+
+// Conversation is a modmail exchange between a user and the staff
+type Conversation struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	UserID   string             `bson:"user_id"`
+	ThreadID string             `bson:"thread_id"`
+	Status   Status             `bson:"status"`
+	Log      []Entry            `bson:"log"`
+	OpenedAt time.Time          `bson:"opened_at"`
+	ClosedAt time.Time          `bson:"closed_at,omitempty"`
+	ClosedBy string             `bson:"closed_by,omitempty"`
+}
+
+// Entry is a message relayed in a conversation
+type Entry struct {
+	AuthorID    string    `bson:"author_id"`
+	AuthorName  string    `bson:"author_name"`
+	Staff       bool      `bson:"staff"`
+	Content     string    `bson:"content"`
+	Attachments []string  `bson:"attachments,omitempty"`
+	Timestamp   time.Time `bson:"ts"`
+}
+
+type block struct {
+	UserID    string    `bson:"_id"`
+	BlockedBy string    `bson:"blocked_by"`
+	BlockedAt time.Time `bson:"blocked_at"`
+}
+
+type Options struct {
+	// Channel is the staff channel where conversations are relayed
+	Channel string
+	// WebsiteURL is used to link the 7TV accounts of users
+	WebsiteURL string
+}
+
+type modmailInst struct {
+	ctx   context.Context
+	query *query.Query
+	ses   *discordgo.Session
+	opt   Options
+
+	conversations *mongodriver.Collection
+	blocks        *mongodriver.Collection
+}
+
+func New(ctx context.Context, mg mongo.Instance, q *query.Query, ldr leader.Instance, ses *discordgo.Session, opt Options) (Instance, error) {
+	inst := &modmailInst{
+		ctx:           ctx,
+		query:         q,
+		ses:           ses,
+		opt:           opt,
+		conversations: mg.Collection(collectionConversations),
+		blocks:        mg.Collection(collectionBlocks),
+	}
+
+	// A user has at most one open conversation, which the unique index enforces against concurrent DMs
+	if _, err := inst.conversations.Indexes().CreateMany(ctx, []mongodriver.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}},
+			Options: options.Index().
+				SetName("user_id_1_open").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": StatusOpen}),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "thread_id", Value: 1}}},
+	}); err != nil {
+		return nil, err
+	}
+
+	ses.AddHandler(leader.Only(ldr, inst.onDirectMessage))
+	ses.AddHandler(leader.Only(ldr, inst.onStaffMessage))
+
+	return inst, nil
+}
+
+func (inst *modmailInst) ByThread(ctx context.Context, threadID string) (*Conversation, error) {
+	conv := &Conversation{}
+	if err := inst.conversations.FindOne(ctx, bson.M{"thread_id": threadID}).Decode(conv); err != nil {
+		if err == mongodriver.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return conv, nil
+}
+
+// openConversation returns the open conversation of a user, or nil if there is none
+func (inst *modmailInst) openConversation(ctx context.Context, userID string) (*Conversation, error) {
+	conv := &Conversation{}
+	if err := inst.conversations.FindOne(ctx, bson.M{"user_id": userID, "status": StatusOpen}).Decode(conv); err != nil {
+		if err == mongodriver.ErrNoDocuments {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return conv, nil
+}
+
+// appendEntry records a relayed message in a conversation
+func (inst *modmailInst) appendEntry(ctx context.Context, conv *Conversation, entry Entry) error {
+	conv.Log = append(conv.Log, entry)
+
+	_, err := inst.conversations.UpdateByID(ctx, conv.ID, bson.M{"$push": bson.M{"log": entry}})
+
+	return err
+}
+
+func (inst *modmailInst) Close(ctx context.Context, conv *Conversation, closedBy *discordgo.User) error {
+	if conv.Status == StatusClosed {
+		return errors.New("this conversation is already closed")
+	}
+
+	conv.Status = StatusClosed
+	conv.ClosedAt = time.Now()
+	conv.ClosedBy = closedBy.ID
+
+	if _, err := inst.conversations.UpdateByID(ctx, conv.ID, bson.M{"$set": bson.M{
+		"status":    conv.Status,
+		"closed_at": conv.ClosedAt,
+		"closed_by": conv.ClosedBy,
+	}}); err != nil {
+		return err
+	}
+
+	inst.notifyUser(conv.UserID, "This conversation was closed by the staff. Sending another message will open a new one")
+
+	if err := inst.sendTranscript(conv, closedBy); err != nil {
+		return err
+	}
+
+	_, err := inst.ses.ChannelEdit(conv.ThreadID, &discordgo.ChannelEdit{
+		Archived: utils.PointerOf(true),
+		Locked:   utils.PointerOf(true),
+	})
+
+	return err
+}
+
+func (inst *modmailInst) Reopen(ctx context.Context, conv *Conversation) error {
+	if conv.Status == StatusOpen {
+		return errors.New("this conversation is already open")
+	}
+
+	// The unique index on open conversations rejects the update if the user has opened another one
+	if _, err := inst.conversations.UpdateByID(ctx, conv.ID, bson.M{
+		"$set":   bson.M{"status": StatusOpen},
+		"$unset": bson.M{"closed_at": "", "closed_by": ""},
+	}); err != nil {
+		if mongodriver.IsDuplicateKeyError(err) {
+			return ErrAlreadyOpen
+		}
+
+		return err
+	}
+
+	if _, err := inst.ses.ChannelEdit(conv.ThreadID, &discordgo.ChannelEdit{
+		Archived: utils.PointerOf(false),
+		Locked:   utils.PointerOf(false),
+	}); err != nil {
+		// Leave the conversation closed, as its thread is
+		if _, rerr := inst.conversations.UpdateByID(ctx, conv.ID, bson.M{"$set": bson.M{
+			"status":    StatusClosed,
+			"closed_at": conv.ClosedAt,
+			"closed_by": conv.ClosedBy,
+		}}); rerr != nil {
+			return rerr
+		}
+
+		return err
+	}
+
+	conv.Status = StatusOpen
+	conv.ClosedAt = time.Time{}
+	conv.ClosedBy = ""
+
+	inst.notifyUser(conv.UserID, "The staff reopened your conversation, you can reply here")
+
+	return nil
+}
+
+func (inst *modmailInst) Block(ctx context.Context, userID string, blockedBy *discordgo.User) error {
+	_, err := inst.blocks.ReplaceOne(ctx, bson.M{"_id": userID}, block{
+		UserID:    userID,
+		BlockedBy: blockedBy.ID,
+		BlockedAt: time.Now(),
+	}, options.Replace().SetUpsert(true))
+
+	return err
+}
+
+func (inst *modmailInst) Unblock(ctx context.Context, userID string) error {
+	_, err := inst.blocks.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{userID, blockID(userID)}}})
+
+	return err
+}
+
+func (inst *modmailInst) Blocked(ctx context.Context, userID string) (bool, error) {
+	n, err := inst.blocks.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": bson.A{userID, blockID(userID)}}})
+
+	return n > 0, err
+}
+
+func (inst *modmailInst) Purge(ctx context.Context, userID string) (int, error) {
+	res, err := inst.conversations.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+
+	// A purge must not lift a block, so the block is kept under a hash of the user's ID instead
+	b := block{}
+	if err := inst.blocks.FindOneAndDelete(ctx, bson.M{"_id": userID}).Decode(&b); err != nil {
+		if errors.Is(err, mongodriver.ErrNoDocuments) {
+			return int(res.DeletedCount), nil
+		}
+
+		return int(res.DeletedCount), err
+	}
+
+	if _, err := inst.blocks.ReplaceOne(ctx, bson.M{"_id": blockID(userID)}, block{
+		UserID:    blockID(userID),
+		BlockedAt: b.BlockedAt,
+	}, options.Replace().SetUpsert(true)); err != nil {
+		return int(res.DeletedCount), err
+	}
+
+	return int(res.DeletedCount), nil
+}
+
+// blockID is what the block of a purged user is kept under, so that the block outlives the user's data
+func blockID(userID string) string {
+	h := sha256.Sum256([]byte("modmail-block:" + userID))
+
+	return "sha256:" + hex.EncodeToString(h[:])
+}
@@ -0,0 +1,355 @@
+package modmail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/compactdisc/internal/transcript"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	// notePrefix marks staff messages in a thread which are not relayed to the user
+	notePrefix = "//"
+	// threadArchiveDuration is how many minutes of inactivity before discord hides a thread
+	threadArchiveDuration = 60 * 24 * 7
+)
+
+// onDirectMessage relays a message sent to the bot into the thread of its conversation, opening one if needed
+func (inst *modmailInst) onDirectMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID != "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+
+	z := zap.S().Named("modmail").With("user_id", m.Author.ID)
+
+	if blocked, err := inst.Blocked(inst.ctx, m.Author.ID); err != nil {
+		z.Errorw("failed to check whether user is blocked", "error", err)
+		return
+	} else if blocked {
+		_, _ = s.ChannelMessageSend(m.ChannelID, "You are not allowed to contact the staff through this bot")
+		return
+	}
+
+	conv, err := inst.openConversation(inst.ctx, m.Author.ID)
+	if err != nil {
+		z.Errorw("failed to get conversation", "error", err)
+		return
+	}
+
+	if conv == nil {
+		if conv, err = inst.open(s, m.Author); err != nil {
+			z.Errorw("failed to open conversation", "error", err)
+			_, _ = s.ChannelMessageSend(m.ChannelID, "Your message could not be delivered, please try again later")
+
+			return
+		}
+
+		_, _ = s.ChannelMessageSend(m.ChannelID, "Your message was sent to the staff, their replies will show up here")
+	}
+
+	if _, err := s.ChannelMessageSendComplex(conv.ThreadID, &discordgo.MessageSend{
+		Embeds:          []*discordgo.MessageEmbed{relayEmbed(m.Message, m.Author.String(), m.Author.AvatarURL("128"), 0x3498DB)},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}); err != nil {
+		z.Errorw("failed to relay message to staff", "error", err)
+		_, _ = s.ChannelMessageSend(m.ChannelID, "Your message could not be delivered, please try again later")
+
+		return
+	}
+
+	if err := inst.appendEntry(inst.ctx, conv, entryOf(m.Message, false)); err != nil {
+		z.Errorw("failed to record message", "error", err)
+	}
+
+	_ = s.MessageReactionAdd(m.ChannelID, m.ID, "✅")
+}
+
+// onStaffMessage relays a message sent by the staff in a conversation thread to the user, without revealing who sent it
+func (inst *modmailInst) onStaffMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" || m.Author == nil || m.Author.Bot || strings.HasPrefix(m.Content, notePrefix) {
+		return
+	}
+
+	ch, err := s.State.Channel(m.ChannelID)
+	if err != nil {
+		if ch, err = s.Channel(m.ChannelID); err != nil {
+			return
+		}
+	}
+
+	if !ch.IsThread() || ch.ParentID != inst.opt.Channel {
+		return
+	}
+
+	z := zap.S().Named("modmail").With("thread_id", m.ChannelID)
+
+	conv, err := inst.ByThread(inst.ctx, m.ChannelID)
+	if err != nil {
+		if err != ErrNotFound {
+			z.Errorw("failed to get conversation", "error", err)
+		}
+
+		return
+	}
+
+	if conv.Status != StatusOpen {
+		_, _ = s.ChannelMessageSendReply(m.ChannelID, "This conversation is closed, reopen it to reply", m.Reference())
+		return
+	}
+
+	dm, err := s.UserChannelCreate(conv.UserID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{
+			Embeds:          []*discordgo.MessageEmbed{relayEmbed(m.Message, "Staff", "", 0x2ECC71)},
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+	}
+
+	if err != nil {
+		z.Warnw("failed to relay message to user", "error", err)
+		_, _ = s.ChannelMessageSendReply(m.ChannelID, fmt.Sprintf("The message could not be delivered: %s", err.Error()), m.Reference())
+
+		return
+	}
+
+	if err := inst.appendEntry(inst.ctx, conv, entryOf(m.Message, true)); err != nil {
+		z.Errorw("failed to record message", "error", err)
+	}
+
+	_ = s.MessageReactionAdd(m.ChannelID, m.ID, "✅")
+}
+
+// open starts a conversation in a new thread, introduced by the user's account info
+func (inst *modmailInst) open(s *discordgo.Session, user *discordgo.User) (*Conversation, error) {
+	starter, err := s.ChannelMessageSendComplex(inst.opt.Channel, &discordgo.MessageSend{
+		Content:         fmt.Sprintf("📨 New conversation with <@%s>", user.ID),
+		Embeds:          []*discordgo.MessageEmbed{inst.userEmbed(user)},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	thread, err := s.MessageThreadStart(inst.opt.Channel, starter.ID, transcript.Truncate(user.Username, 90), threadArchiveDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &Conversation{
+		ID:       primitive.NewObjectIDFromTimestamp(time.Now()),
+		UserID:   user.ID,
+		ThreadID: thread.ID,
+		Status:   StatusOpen,
+		Log:      []Entry{},
+		OpenedAt: time.Now(),
+	}
+
+	if _, err := inst.conversations.InsertOne(inst.ctx, conv); err != nil {
+		if !mongodriver.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		// Another message of the user opened a conversation meanwhile, which this one joins
+		if _, err := s.ChannelDelete(thread.ID); err != nil {
+			zap.S().Named("modmail").Warnw("failed to delete duplicate thread", "error", err, "thread_id", thread.ID)
+		}
+
+		_ = s.ChannelMessageDelete(inst.opt.Channel, starter.ID)
+
+		if conv, err = inst.openConversation(inst.ctx, user.ID); err == nil && conv == nil {
+			err = errors.New("the conversation opened meanwhile was closed")
+		}
+
+		return conv, err
+	}
+
+	return conv, nil
+}
+
+// userEmbed describes the discord account of a user and the 7TV account linked to it
+func (inst *modmailInst) userEmbed(user *discordgo.User) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    user.String(),
+			IconURL: user.AvatarURL("128"),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Discord Account",
+				Value:  fmt.Sprintf("<@%s> (%s)", user.ID, user.ID),
+				Inline: true,
+			},
+		},
+	}
+
+	if ts, err := discordgo.SnowflakeTimestamp(user.ID); err == nil {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Created",
+			Value:  fmt.Sprintf("<t:%d:R>", ts.Unix()),
+			Inline: true,
+		})
+	}
+
+	u, err := inst.query.Users(inst.ctx, bson.M{
+		"connections": bson.M{"$elemMatch": bson.M{
+			"platform": structures.UserConnectionPlatformDiscord,
+			"id":       user.ID,
+		}},
+	}).First()
+	if err != nil {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "7TV Account",
+			Value: "None",
+		})
+
+		return embed
+	}
+
+	roles := make([]string, len(u.Roles))
+	for i, rol := range u.Roles {
+		roles[i] = rol.Name
+	}
+
+	if len(roles) == 0 {
+		roles = append(roles, "None")
+	}
+
+	embed.Color = int(u.GetHighestRole().Color)
+	embed.Fields = append(embed.Fields,
+		&discordgo.MessageEmbedField{
+			Name:  "7TV Account",
+			Value: fmt.Sprintf("[%s (%s)](%s)", u.DisplayName, u.Username, u.WebURL(inst.opt.WebsiteURL)),
+		},
+		&discordgo.MessageEmbedField{
+			Name:   "7TV Account Created",
+			Value:  fmt.Sprintf("<t:%d:R>", u.ID.Timestamp().Unix()),
+			Inline: true,
+		},
+		&discordgo.MessageEmbedField{
+			Name:   "Roles",
+			Value:  transcript.Truncate(strings.Join(roles, ", "), 1024),
+			Inline: true,
+		},
+	)
+
+	return embed
+}
+
+// notifyUser sends a notice about their conversation to a user, ignoring failures as their DMs may be closed
+func (inst *modmailInst) notifyUser(userID string, content string) {
+	dm, err := inst.ses.UserChannelCreate(userID)
+	if err != nil {
+		return
+	}
+
+	_, _ = inst.ses.ChannelMessageSend(dm.ID, content)
+}
+
+// sendTranscript posts the transcript of a closed conversation to the staff channel
+func (inst *modmailInst) sendTranscript(conv *Conversation, closedBy *discordgo.User) error {
+	msgs := make([]*discordgo.Message, len(conv.Log))
+	for i, entry := range conv.Log {
+		msgs[i] = entry.message()
+	}
+
+	title := fmt.Sprintf("Modmail conversation with %s", conv.UserID)
+	name := fmt.Sprintf("modmail-%s-%d", conv.UserID, conv.OpenedAt.Unix())
+
+	files, err := transcript.Files(name, title, msgs)
+	if err != nil {
+		return err
+	}
+
+	_, err = inst.ses.ChannelMessageSendComplex(inst.opt.Channel, &discordgo.MessageSend{
+		Content:         fmt.Sprintf("📪 Conversation with <@%s> in <#%s> closed by <@%s> after %d messages", conv.UserID, conv.ThreadID, closedBy.ID, len(conv.Log)),
+		Files:           files,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+
+	return err
+}
+
+// relayEmbed renders a relayed message
+func relayEmbed(msg *discordgo.Message, name string, iconURL string, color int) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Description: transcript.Truncate(msg.Content, 4096),
+		Color:       color,
+		Timestamp:   msg.Timestamp.Format(time.RFC3339),
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    name,
+			IconURL: iconURL,
+		},
+		Fields: []*discordgo.MessageEmbedField{},
+	}
+
+	if len(msg.Attachments) > 0 {
+		a := make([]string, len(msg.Attachments))
+		for i, v := range msg.Attachments {
+			a[i] = fmt.Sprintf("[%s](%s)", v.Filename, v.URL)
+
+			if embed.Image == nil && strings.HasPrefix(v.ContentType, "image/") {
+				embed.Image = &discordgo.MessageEmbedImage{URL: v.URL}
+			}
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Attachments",
+			Value: transcript.Truncate(strings.Join(a, "\n"), 1024),
+		})
+	}
+
+	return embed
+}
+
+func entryOf(msg *discordgo.Message, staff bool) Entry {
+	attachments := make([]string, len(msg.Attachments))
+	for i, a := range msg.Attachments {
+		attachments[i] = a.URL
+	}
+
+	return Entry{
+		AuthorID:    msg.Author.ID,
+		AuthorName:  msg.Author.String(),
+		Staff:       staff,
+		Content:     msg.Content,
+		Attachments: attachments,
+		Timestamp:   msg.Timestamp,
+	}
+}
+
+// message converts an entry to a message, so that it can be rendered in a transcript
+func (e Entry) message() *discordgo.Message {
+	name, discriminator := e.AuthorName, ""
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		name, discriminator = name[:i], name[i+1:]
+	}
+
+	if e.Staff {
+		name = "[staff] " + name
+	}
+
+	msg := &discordgo.Message{
+		Author: &discordgo.User{
+			ID:            e.AuthorID,
+			Username:      name,
+			Discriminator: discriminator,
+		},
+		Content:     e.Content,
+		Timestamp:   e.Timestamp,
+		Attachments: make([]*discordgo.MessageAttachment, len(e.Attachments)),
+	}
+
+	for i, url := range e.Attachments {
+		msg.Attachments[i] = &discordgo.MessageAttachment{URL: url}
+	}
+
+	return msg
+}
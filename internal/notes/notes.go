@@ -0,0 +1,125 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionNotes holds the notes moderators keep about users
+const collectionNotes mongo.CollectionName = "compactdisc_notes"
+
+// ErrNotFound is returned when a note does not exist
+var ErrNotFound = errors.New("note not found")
+
+// Note is a moderator's note about a discord member, a 7TV user, or both when their accounts are linked
+type Note struct {
+	ID primitive.ObjectID `bson:"_id"`
+	// DiscordID is the discord account the note is about
+	DiscordID string `bson:"discord_id,omitempty"`
+	// UserID is the 7TV account the note is about
+	UserID  primitive.ObjectID `bson:"user_id,omitempty"`
+	Content string             `bson:"content"`
+	// AuthorID is the discord ID of the moderator who wrote the note
+	AuthorID  string    `bson:"author_id"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// Subject identifies who notes are about. Either or both IDs may be set
+type Subject struct {
+	DiscordID string
+	UserID    primitive.ObjectID
+}
+
+func (s Subject) filter() bson.M {
+	or := bson.A{}
+
+	if s.DiscordID != "" {
+		or = append(or, bson.M{"discord_id": s.DiscordID})
+	}
+
+	if !s.UserID.IsZero() {
+		or = append(or, bson.M{"user_id": s.UserID})
+	}
+
+	return bson.M{"$or": or}
+}
+
+// Setup creates the indexes notes are listed by
+func Setup(gctx global.Context, ctx context.Context) error {
+	_, err := gctx.Inst().Mongo.Collection(collectionNotes).Indexes().CreateMany(ctx, []mongodriver.IndexModel{
+		{Keys: bson.D{{Key: "discord_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+
+	return err
+}
+
+// Add writes a note about a subject
+func Add(gctx global.Context, ctx context.Context, subject Subject, authorID string, content string) (Note, error) {
+	note := Note{
+		ID:        primitive.NewObjectID(),
+		DiscordID: subject.DiscordID,
+		UserID:    subject.UserID,
+		Content:   content,
+		AuthorID:  authorID,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := gctx.Inst().Mongo.Collection(collectionNotes).InsertOne(ctx, note)
+
+	return note, err
+}
+
+// List returns the notes about a subject under any of its accounts, most recent first
+func List(gctx global.Context, ctx context.Context, subject Subject) ([]Note, error) {
+	notes := []Note{}
+
+	if subject.DiscordID == "" && subject.UserID.IsZero() {
+		return notes, nil
+	}
+
+	cur, err := gctx.Inst().Mongo.Collection(collectionNotes).Find(ctx, subject.filter(), options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cur.All(ctx, &notes); err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+// Delete removes a note and returns it
+func Delete(gctx global.Context, ctx context.Context, id primitive.ObjectID) (Note, error) {
+	note := Note{}
+
+	err := gctx.Inst().Mongo.Collection(collectionNotes).FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&note)
+	if errors.Is(err, mongodriver.ErrNoDocuments) {
+		return note, ErrNotFound
+	}
+
+	return note, err
+}
+
+// Purge removes every note about a subject under any of its accounts, returning how many were removed
+func Purge(gctx global.Context, ctx context.Context, subject Subject) (int, error) {
+	if subject.DiscordID == "" && subject.UserID.IsZero() {
+		return 0, nil
+	}
+
+	res, err := gctx.Inst().Mongo.Collection(collectionNotes).DeleteMany(ctx, subject.filter())
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res.DeletedCount), nil
+}
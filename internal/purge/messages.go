@@ -0,0 +1,152 @@
+package purge
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-redis/redis/v8"
+	cdredis "github.com/seventv/common/redis"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.uber.org/zap"
+)
+
+const (
+	// bulkDeleteMaxAge is how old messages can be for discord to bulk delete them
+	bulkDeleteMaxAge = time.Hour*24*14 - time.Minute
+	// bulkDeleteMaxCount is how many messages discord can bulk delete at once
+	bulkDeleteMaxCount = 100
+	// markerTTL is how long the deletion logs of a purged message are suppressed for
+	markerTTL = time.Minute
+)
+
+// Messages deletes the messages a member sent in every channel since a time, as found in the message cache.
+// The messages which were deleted are returned so that a transcript can be made of them
+func Messages(gctx global.Context, s *discordgo.Session, authorID string, since time.Time) ([]*discordgo.Message, error) {
+	z := zap.S().Named("purge").With("author_id", authorID)
+
+	msgs, err := gctx.Inst().Messages.ByAuthor(gctx, authorID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	// The purge is logged as a whole by the caller, so the individual deletions must not be.
+	// The messages are marked before being deleted, as the deletion events may arrive before discord responds
+	if err := mark(gctx, msgs); err != nil {
+		return nil, err
+	}
+
+	byChannel := map[string][]*discordgo.Message{}
+	for _, msg := range msgs {
+		byChannel[msg.ChannelID] = append(byChannel[msg.ChannelID], msg)
+	}
+
+	deleted := make([]*discordgo.Message, 0, len(msgs))
+	cutoff := time.Now().Add(-bulkDeleteMaxAge)
+
+	// forget drops the cached copies of messages once discord has deleted them
+	forget := func(msgs ...*discordgo.Message) {
+		if err := gctx.Inst().Messages.Delete(gctx, msgs...); err != nil {
+			z.Errorw("failed to delete purged messages from cache", "error", err)
+		}
+
+		deleted = append(deleted, msgs...)
+	}
+
+	for channelID, channelMsgs := range byChannel {
+		bulk := []*discordgo.Message{}
+
+		for _, msg := range channelMsgs {
+			if msg.Timestamp.After(cutoff) {
+				bulk = append(bulk, msg)
+				continue
+			}
+
+			// Too old to be bulk deleted
+			if err := s.ChannelMessageDelete(channelID, msg.ID); err != nil {
+				z.Warnw("failed to delete message", "error", err, "message_id", msg.ID)
+				continue
+			}
+
+			forget(msg)
+		}
+
+		for start := 0; start < len(bulk); start += bulkDeleteMaxCount {
+			end := start + bulkDeleteMaxCount
+			if end > len(bulk) {
+				end = len(bulk)
+			}
+
+			// Discord refuses to bulk delete a single message
+			if end-start == 1 {
+				if err := s.ChannelMessageDelete(channelID, bulk[start].ID); err != nil {
+					z.Warnw("failed to delete message", "error", err, "message_id", bulk[start].ID)
+					continue
+				}
+
+				forget(bulk[start])
+
+				continue
+			}
+
+			ids := make([]string, end-start)
+			for i, msg := range bulk[start:end] {
+				ids[i] = msg.ID
+			}
+
+			if err := s.ChannelMessagesBulkDelete(channelID, ids); err != nil {
+				z.Warnw("failed to bulk delete messages", "error", err, "channel_id", channelID)
+				continue
+			}
+
+			forget(bulk[start:end]...)
+		}
+	}
+
+	z.Infow("messages purged", "count", len(deleted), "channels", len(byChannel))
+
+	return deleted, nil
+}
+
+// mark records the messages which are about to be purged, so that the deletion handlers don't log them individually
+func mark(gctx global.Context, msgs []*discordgo.Message) error {
+	_, err := gctx.Inst().Redis.RawClient().Pipelined(gctx, func(pipe redis.Pipeliner) error {
+		for _, msg := range msgs {
+			pipe.Set(gctx, markerKey(gctx, msg.ID).String(), "1", markerTTL)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Purged returns which of the given messages were deleted by a purge
+func Purged(gctx global.Context, ids ...string) map[string]bool {
+	result := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return result
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = markerKey(gctx, id).String()
+	}
+
+	values, err := gctx.Inst().Redis.RawClient().MGet(gctx, keys...).Result()
+	if err != nil {
+		zap.S().Named("purge").Warnw("failed to check for purged messages", "error", err)
+		return result
+	}
+
+	for i, v := range values {
+		if v != nil {
+			result[ids[i]] = true
+		}
+	}
+
+	return result
+}
+
+func markerKey(gctx global.Context, messageID string) cdredis.Key {
+	return gctx.Inst().Redis.ComposeKey("compactdisc", "purged", messageID)
+}
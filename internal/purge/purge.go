@@ -0,0 +1,191 @@
+package purge
+
+import (
+	"errors"
+	"time"
+
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/compactdisc"
+	"github.com/seventv/compactdisc/internal/archive"
+	"github.com/seventv/compactdisc/internal/global"
+	"github.com/seventv/compactdisc/internal/notes"
+	"github.com/seventv/compactdisc/internal/rolesync"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// User deletes everything compactdisc holds about a person, by their discord account, their 7TV account, or both.
+// The account which isn't given is resolved when the two are linked, and data held under either of them is deleted
+func User(gctx global.Context, discordID string, userID primitive.ObjectID) (compactdisc.PurgeReport, error) {
+	report := compactdisc.PurgeReport{
+		DiscordID: discordID,
+		UserID:    userID,
+		Deleted:   map[string]int{},
+	}
+
+	if discordID == "" && userID.IsZero() {
+		return report, errors.New("either a user id or a discord id is required")
+	}
+
+	if err := resolve(gctx, &report); err != nil {
+		return report, err
+	}
+
+	discordID, userID = report.DiscordID, report.UserID
+
+	z := zap.S().Named("purge").With("discord_id", discordID, "user_id", userID.Hex())
+
+	if discordID != "" {
+		if err := discordData(gctx, z, discordID, report); err != nil {
+			return report, err
+		}
+	}
+
+	// Notes kept by staff, under either account
+	deleted, err := notes.Purge(gctx, gctx, notes.Subject{DiscordID: discordID, UserID: userID})
+	if err != nil {
+		return report, err
+	}
+
+	report.Deleted["notes"] = deleted
+
+	// Browsable command responses about either account
+	subjects := []string{discordID}
+	if !userID.IsZero() {
+		subjects = append(subjects, userID.Hex())
+	}
+
+	pages, err := dropPages(gctx, subjects...)
+	if err != nil {
+		return report, err
+	}
+
+	report.Deleted["pages"] = pages
+
+	z.Infow("user purged", "deleted", report.Deleted)
+
+	return report, nil
+}
+
+// dropPages deletes the stored pages of the paginated command responses about discord or 7TV accounts, returning how many were deleted
+func dropPages(gctx global.Context, subjects ...string) (int, error) {
+	cl := gctx.Inst().Redis.RawClient()
+	count := 0
+
+	for _, subject := range subjects {
+		if subject == "" {
+			continue
+		}
+
+		about := gctx.Inst().Redis.ComposeKey("compactdisc", "pages", "about", subject).String()
+
+		ids, err := cl.SMembers(gctx, about).Result()
+		if err != nil {
+			return count, err
+		}
+
+		if len(ids) > 0 {
+			keys := make([]string, len(ids))
+			for i, id := range ids {
+				keys[i] = gctx.Inst().Redis.ComposeKey("compactdisc", "pages", id).String()
+			}
+
+			n, err := cl.Del(gctx, keys...).Result()
+			if err != nil {
+				return count, err
+			}
+
+			count += int(n)
+		}
+
+		if err := cl.Del(gctx, about).Err(); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// discordData deletes the data held under a discord account, counting it in the report
+func discordData(gctx global.Context, z *zap.SugaredLogger, discordID string, report compactdisc.PurgeReport) error {
+	// Cached messages, along with their revisions and archived attachments
+	msgs, err := gctx.Inst().Messages.ByAuthor(gctx, discordID, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range msgs {
+		n, err := archive.Delete(gctx, msg)
+		if err != nil {
+			z.Errorw("failed to delete archived attachments", "error", err, "message_id", msg.ID)
+		}
+
+		report.Deleted["attachments"] += n
+	}
+
+	if err := gctx.Inst().Messages.Delete(gctx, msgs...); err != nil {
+		return err
+	}
+
+	report.Deleted["messages"] = len(msgs)
+
+	// Timeout tracking
+	n, err := gctx.Inst().Redis.RawClient().Del(gctx,
+		gctx.Inst().Redis.ComposeKey("compactdisc", "timeout", gctx.Config().Discord.GuildID, discordID).String(),
+	).Result()
+	if err != nil {
+		return err
+	}
+
+	report.Deleted["timeouts"] = int(n)
+
+	// Modmail conversations
+	if gctx.Inst().Modmail != nil {
+		n, err := gctx.Inst().Modmail.Purge(gctx, discordID)
+		if err != nil {
+			return err
+		}
+
+		report.Deleted["modmail_conversations"] = n
+	}
+
+	return nil
+}
+
+// resolve fills in the account of a person which wasn't given, if they linked their discord account to 7TV
+func resolve(gctx global.Context, report *compactdisc.PurgeReport) error {
+	if report.DiscordID != "" && report.UserID.IsZero() {
+		user, err := rolesync.LinkedUser(gctx, gctx, report.DiscordID)
+		if err == nil {
+			report.UserID = user.ID
+		} else if !errors.Is(err, rolesync.ErrNotLinked) {
+			return err
+		}
+
+		return nil
+	}
+
+	if report.DiscordID == "" {
+		// The 7TV account may have been deleted already, in which case there is no discord account to find
+		err := gctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(gctx, bson.M{"_id": report.UserID}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+		if errors.Is(err, mongodriver.ErrNoDocuments) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		user, err := gctx.Inst().Query.Users(gctx, bson.M{"_id": report.UserID}).First()
+		if err != nil {
+			return err
+		}
+
+		if con, ind, _ := user.Connections.Discord(); ind != -1 {
+			report.DiscordID = con.ID
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package roles
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/api/data/query"
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/common/structures/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// maxAge is how long cached roles are trusted for when no invalidation was received,
+// in case the mongo change stream is unavailable or a gateway event was missed
+const maxAge = time.Minute * 10
+
+// Instance is an in-memory cache of 7TV roles and discord guild roles
+type Instance interface {
+	// AppRoles returns all 7TV roles
+	AppRoles(ctx context.Context) ([]structures.Role, error)
+	// GuildRoles returns the roles of a discord guild, keyed by their ID. The returned map must not be modified
+	GuildRoles(guildID string) (map[string]*discordgo.Role, error)
+	// InvalidateAppRoles drops the cached 7TV roles
+	InvalidateAppRoles()
+	// InvalidateGuildRoles drops the cached roles of a discord guild
+	InvalidateGuildRoles(guildID string)
+}
+
+type rolesInst struct {
+	query *query.Query
+	ses   *discordgo.Session
+
+	appMx      sync.Mutex
+	app        []structures.Role
+	appFetched time.Time
+
+	guildMx sync.RWMutex
+	guilds  map[string]*guildRoles
+}
+
+type guildRoles struct {
+	roles   map[string]*discordgo.Role
+	fetched time.Time
+}
+
+func New(ctx context.Context, mg mongo.Instance, q *query.Query, ses *discordgo.Session) Instance {
+	inst := &rolesInst{
+		query:  q,
+		ses:    ses,
+		guilds: make(map[string]*guildRoles),
+	}
+
+	// Keep guild roles up to date with the gateway
+	ses.AddHandler(func(s *discordgo.Session, e *discordgo.GuildRoleCreate) {
+		inst.setGuildRole(e.GuildID, e.Role)
+	})
+	ses.AddHandler(func(s *discordgo.Session, e *discordgo.GuildRoleUpdate) {
+		inst.setGuildRole(e.GuildID, e.Role)
+	})
+	ses.AddHandler(func(s *discordgo.Session, e *discordgo.GuildRoleDelete) {
+		inst.deleteGuildRole(e.GuildID, e.RoleID)
+	})
+
+	// Keep app roles up to date with mongo
+	go inst.watchAppRoles(ctx, mg)
+
+	return inst
+}
+
+func (inst *rolesInst) AppRoles(ctx context.Context) ([]structures.Role, error) {
+	inst.appMx.Lock()
+	defer inst.appMx.Unlock()
+
+	if inst.app != nil && time.Since(inst.appFetched) < maxAge {
+		return inst.app, nil
+	}
+
+	roles, err := inst.query.Roles(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	inst.app = roles
+	inst.appFetched = time.Now()
+
+	return roles, nil
+}
+
+func (inst *rolesInst) InvalidateAppRoles() {
+	inst.appMx.Lock()
+	defer inst.appMx.Unlock()
+
+	inst.app = nil
+}
+
+func (inst *rolesInst) GuildRoles(guildID string) (map[string]*discordgo.Role, error) {
+	inst.guildMx.RLock()
+	gr, ok := inst.guilds[guildID]
+	inst.guildMx.RUnlock()
+
+	if ok && time.Since(gr.fetched) < maxAge {
+		return gr.roles, nil
+	}
+
+	roles, err := inst.ses.GuildRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]*discordgo.Role, len(roles))
+	for _, rol := range roles {
+		m[rol.ID] = rol
+	}
+
+	inst.guildMx.Lock()
+	inst.guilds[guildID] = &guildRoles{
+		roles:   m,
+		fetched: time.Now(),
+	}
+	inst.guildMx.Unlock()
+
+	return m, nil
+}
+
+func (inst *rolesInst) InvalidateGuildRoles(guildID string) {
+	inst.guildMx.Lock()
+	defer inst.guildMx.Unlock()
+
+	delete(inst.guilds, guildID)
+}
+
+// setGuildRole adds or replaces a role in a cached guild.
+// The map is copied so that readers holding the previous map are unaffected
+func (inst *rolesInst) setGuildRole(guildID string, role *discordgo.Role) {
+	if role == nil {
+		return
+	}
+
+	inst.guildMx.Lock()
+	defer inst.guildMx.Unlock()
+
+	gr, ok := inst.guilds[guildID]
+	if !ok {
+		return // nothing cached yet, the next read will fetch it
+	}
+
+	m := make(map[string]*discordgo.Role, len(gr.roles)+1)
+	for k, v := range gr.roles {
+		m[k] = v
+	}
+
+	m[role.ID] = role
+
+	inst.guilds[guildID] = &guildRoles{
+		roles:   m,
+		fetched: gr.fetched,
+	}
+}
+
+// deleteGuildRole removes a role from a cached guild
+func (inst *rolesInst) deleteGuildRole(guildID string, roleID string) {
+	inst.guildMx.Lock()
+	defer inst.guildMx.Unlock()
+
+	gr, ok := inst.guilds[guildID]
+	if !ok {
+		return
+	}
+
+	m := make(map[string]*discordgo.Role, len(gr.roles))
+	for k, v := range gr.roles {
+		if k != roleID {
+			m[k] = v
+		}
+	}
+
+	inst.guilds[guildID] = &guildRoles{
+		roles:   m,
+		fetched: gr.fetched,
+	}
+}
+
+// watchAppRoles invalidates the app roles whenever the roles collection changes
+func (inst *rolesInst) watchAppRoles(ctx context.Context, mg mongo.Instance) {
+	z := zap.S().Named("roles")
+
+	for {
+		cs, err := mg.Collection(mongo.CollectionNameRoles).Watch(ctx, mongodriver.Pipeline{})
+		if err != nil {
+			// change streams require a replica set, fall back to maxAge expiry
+			z.Warnw("failed to watch roles collection, falling back to periodic refresh", "error", err)
+			return
+		}
+
+		for cs.Next(ctx) {
+			inst.InvalidateAppRoles()
+		}
+
+		if err := cs.Err(); err != nil && ctx.Err() == nil {
+			z.Errorw("roles change stream closed", "error", err)
+		}
+
+		_ = cs.Close(context.Background())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * 5):
+			inst.InvalidateAppRoles() // changes may have been missed while reconnecting
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package rolesync
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/compactdisc"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// membersTimeout is how long the gateway has to send every member of the guild
+	membersTimeout = time.Minute
+	// linkedBatchSize is how many discord IDs are looked up per query
+	linkedBatchSize = 1000
+)
+
+// Diagnose reports, for every 7TV role linked to a discord role, whether it can be synced and how many members are out of sync
+func Diagnose(gctx global.Context, ctx context.Context) (compactdisc.RoleReport, error) {
+	report := compactdisc.RoleReport{
+		GeneratedAt: time.Now(),
+		Roles:       []compactdisc.RoleReportEntry{},
+	}
+
+	appRoles, err := gctx.Inst().Roles.AppRoles(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	dis := gctx.Inst().Discord.Session()
+	guildID := gctx.Config().Discord.GuildID
+
+	guildRoles, err := gctx.Inst().Roles.GuildRoles(guildID)
+	if err != nil {
+		return report, err
+	}
+
+	botMember, err := dis.State.Member(guildID, dis.State.User.ID)
+	if err != nil {
+		return report, err
+	}
+
+	botRank := 0
+
+	for _, roleID := range botMember.Roles {
+		if rol, ok := guildRoles[roleID]; ok && rol.Position > botRank {
+			botRank = rol.Position
+		}
+	}
+
+	members, err := guildMembers(ctx, dis, guildID)
+	if err != nil {
+		return report, err
+	}
+
+	linked, err := linkedRoles(gctx, ctx, members)
+	if err != nil {
+		return report, err
+	}
+
+	report.Members = len(linked)
+
+	for _, rol := range appRoles {
+		if rol.DiscordID == 0 {
+			continue // not linked to discord
+		}
+
+		entry := compactdisc.RoleReportEntry{
+			RoleID:    rol.ID,
+			Name:      rol.Name,
+			DiscordID: strconv.FormatUint(rol.DiscordID, 10),
+		}
+
+		grole, ok := guildRoles[entry.DiscordID]
+		entry.Exists = ok
+
+		switch {
+		case !ok:
+			entry.Reason = "missing from the guild"
+		case grole.Managed:
+			entry.Reason = "managed by an integration"
+		case grole.Position >= botRank:
+			entry.Reason = "at or above the bot's highest role"
+		default:
+			entry.Manageable = true
+		}
+
+		if ok {
+			for _, member := range members {
+				roleIDs, isLinked := linked[member.User.ID]
+				if !isLinked {
+					continue // unlinked members are never synced
+				}
+
+				_, expected := roleIDs[rol.ID]
+
+				actual := false
+
+				for _, roleID := range member.Roles {
+					if roleID == entry.DiscordID {
+						actual = true
+						break
+					}
+				}
+
+				switch {
+				case expected && !actual:
+					entry.Missing++
+				case !expected && actual:
+					entry.Extra++
+				}
+			}
+		}
+
+		report.Roles = append(report.Roles, entry)
+	}
+
+	return report, nil
+}
+
+// guildMembers requests every member of a guild from the gateway, which sends them in chunks much faster than the REST API pages them
+func guildMembers(ctx context.Context, dis *discordgo.Session, guildID string) ([]*discordgo.Member, error) {
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	mx := sync.Mutex{}
+	result := []*discordgo.Member{}
+	received := 0
+	done := make(chan struct{})
+
+	remove := dis.AddHandler(func(s *discordgo.Session, c *discordgo.GuildMembersChunk) {
+		if c.Nonce != nonce {
+			return
+		}
+
+		mx.Lock()
+		defer mx.Unlock()
+
+		result = append(result, c.Members...)
+		received++
+
+		if received == c.ChunkCount {
+			close(done)
+		}
+	})
+	defer remove()
+
+	if err := dis.RequestGuildMembers(guildID, "", 0, nonce, false); err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(membersTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout.C:
+		return nil, errors.New("timed out waiting for the guild's members")
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	return result, nil
+}
+
+// linkedRoles returns the 7TV role IDs of the members who have a linked 7TV account, keyed by discord ID.
+// Their roles are resolved by the query layer like when syncing them, including default and entitlement roles
+func linkedRoles(gctx global.Context, ctx context.Context, members []*discordgo.Member) (map[string]map[primitive.ObjectID]struct{}, error) {
+	result := map[string]map[primitive.ObjectID]struct{}{}
+
+	for start := 0; start < len(members); start += linkedBatchSize {
+		end := start + linkedBatchSize
+		if end > len(members) {
+			end = len(members)
+		}
+
+		ids := make([]string, 0, end-start)
+		for _, member := range members[start:end] {
+			ids = append(ids, member.User.ID)
+		}
+
+		// Find out who is linked first, as the query layer would fail a batch in which nobody is
+		cur, err := gctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).Find(ctx, bson.M{
+			"connections": bson.M{"$elemMatch": bson.M{
+				"platform": structures.UserConnectionPlatformDiscord,
+				"id":       bson.M{"$in": ids},
+			}},
+		}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+
+		found := []structures.User{}
+		if err := cur.All(ctx, &found); err != nil {
+			return nil, err
+		}
+
+		if len(found) == 0 {
+			continue
+		}
+
+		userIDs := make([]primitive.ObjectID, len(found))
+		for i, user := range found {
+			userIDs[i] = user.ID
+		}
+
+		users, err := gctx.Inst().Query.Users(ctx, bson.M{"_id": bson.M{"$in": userIDs}}).Items()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			con, ind, _ := user.Connections.Discord()
+			if ind == -1 {
+				continue
+			}
+
+			roleIDs := make(map[primitive.ObjectID]struct{}, len(user.Roles))
+			for _, rol := range user.Roles {
+				roleIDs[rol.ID] = struct{}{}
+			}
+
+			result[con.ID] = roleIDs
+		}
+	}
+
+	return result, nil
+}
+
+// ReportCSV renders a role report as CSV
+func ReportCSV(report compactdisc.RoleReport) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+
+	_ = w.Write([]string{"role_id", "name", "discord_id", "exists", "manageable", "reason", "missing", "extra"})
+
+	for _, entry := range report.Roles {
+		_ = w.Write([]string{
+			entry.RoleID.Hex(),
+			entry.Name,
+			entry.DiscordID,
+			strconv.FormatBool(entry.Exists),
+			strconv.FormatBool(entry.Manageable),
+			entry.Reason,
+			strconv.Itoa(entry.Missing),
+			strconv.Itoa(entry.Extra),
+		})
+	}
+
+	w.Flush()
+
+	return buf.Bytes(), w.Error()
+}
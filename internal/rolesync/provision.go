@@ -0,0 +1,330 @@
+package rolesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/common/redis"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/common/utils"
+	"github.com/seventv/compactdisc"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// stylingTTL is how long drift alerts are suppressed for while the bot restyles the linked roles
+const stylingTTL = time.Minute
+
+// ErrUnknownRole is returned when a 7TV role to provision does not exist
+var ErrUnknownRole = errors.New("unknown 7TV role")
+
+// Provision creates discord roles for 7TV roles which aren't linked to one, or whose discord role was deleted,
+// and links them by writing the ID of the new discord role to the 7TV role. The linked roles are then restyled
+func Provision(gctx global.Context, ctx context.Context, roleIDs []primitive.ObjectID) (compactdisc.ProvisionReport, error) {
+	report := compactdisc.ProvisionReport{
+		Created: []compactdisc.ProvisionedRole{},
+		Linked:  []compactdisc.ProvisionedRole{},
+		Styled:  []string{},
+	}
+
+	appRoles, err := gctx.Inst().Roles.AppRoles(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	dis := gctx.Inst().Discord.Session()
+	guildID := gctx.Config().Discord.GuildID
+
+	guildRoles, err := gctx.Inst().Roles.GuildRoles(guildID)
+	if err != nil {
+		return report, err
+	}
+
+	byID := make(map[primitive.ObjectID]structures.Role, len(appRoles))
+	for _, rol := range appRoles {
+		byID[rol.ID] = rol
+	}
+
+	z := zap.S().Named("rolesync").With("guild_id", guildID)
+
+	for _, roleID := range roleIDs {
+		rol, ok := byID[roleID]
+		if !ok {
+			return report, fmt.Errorf("%w: %s", ErrUnknownRole, roleID.Hex())
+		}
+
+		if rol.DiscordID != 0 {
+			discordID := strconv.FormatUint(rol.DiscordID, 10)
+
+			if _, ok := guildRoles[discordID]; ok {
+				report.Linked = append(report.Linked, compactdisc.ProvisionedRole{
+					RoleID:    rol.ID,
+					Name:      rol.Name,
+					DiscordID: discordID,
+				})
+
+				continue // already linked to an existing role
+			}
+		}
+
+		grole, err := dis.GuildRoleCreate(guildID, &discordgo.RoleParams{
+			Name:        rol.Name,
+			Color:       utils.PointerOf(DiscordColor(rol.Color)),
+			Hoist:       utils.PointerOf(false),
+			Permissions: utils.PointerOf(int64(0)),
+			Mentionable: utils.PointerOf(false),
+		})
+		if err != nil {
+			return report, err
+		}
+
+		discordID, _ := strconv.ParseUint(grole.ID, 10, 64)
+
+		// The role is only linked if nobody linked it meanwhile, i.e a concurrent provisioning
+		filter := bson.M{"_id": rol.ID, "discord_id": rol.DiscordID}
+		if rol.DiscordID == 0 {
+			filter = bson.M{"_id": rol.ID, "$or": bson.A{
+				bson.M{"discord_id": bson.M{"$exists": false}},
+				bson.M{"discord_id": 0},
+			}}
+		}
+
+		res, err := gctx.Inst().Mongo.Collection(mongo.CollectionNameRoles).UpdateOne(ctx, filter, bson.M{
+			"$set": bson.M{"discord_id": discordID},
+		})
+		if err != nil || res.MatchedCount == 0 {
+			// Don't leave behind a role which nothing links to
+			if derr := dis.GuildRoleDelete(guildID, grole.ID); derr != nil {
+				z.Errorw("failed to delete unlinked discord role", "error", derr, "discord_id", grole.ID)
+			}
+
+			if err != nil {
+				return report, err
+			}
+
+			// Report the role which won instead
+			current := structures.Role{}
+			if err := gctx.Inst().Mongo.Collection(mongo.CollectionNameRoles).FindOne(ctx, bson.M{"_id": rol.ID}).Decode(&current); err != nil {
+				return report, err
+			}
+
+			report.Linked = append(report.Linked, compactdisc.ProvisionedRole{
+				RoleID:    rol.ID,
+				Name:      rol.Name,
+				DiscordID: strconv.FormatUint(current.DiscordID, 10),
+			})
+
+			continue
+		}
+
+		z.Infow("discord role provisioned", "role_id", rol.ID.Hex(), "discord_id", grole.ID)
+
+		report.Created = append(report.Created, compactdisc.ProvisionedRole{
+			RoleID:    rol.ID,
+			Name:      rol.Name,
+			DiscordID: grole.ID,
+		})
+	}
+
+	if len(report.Created) > 0 {
+		// The new roles and links must be seen by the restyle, regardless of when the change stream and gateway catch up
+		gctx.Inst().Roles.InvalidateAppRoles()
+		gctx.Inst().Roles.InvalidateGuildRoles(guildID)
+	}
+
+	report.Styled, err = Style(gctx, ctx)
+
+	return report, err
+}
+
+// Style updates the name, color and position of the linked discord roles to match their 7TV roles.
+// Roles which the bot cannot manage are left alone. The names of the restyled roles are returned
+func Style(gctx global.Context, ctx context.Context) ([]string, error) {
+	appRoles, err := gctx.Inst().Roles.AppRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dis := gctx.Inst().Discord.Session()
+	guildID := gctx.Config().Discord.GuildID
+
+	guildRoles, err := gctx.Inst().Roles.GuildRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	botMember, err := dis.State.Member(guildID, dis.State.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	botRank := 0
+
+	for _, roleID := range botMember.Roles {
+		if rol, ok := guildRoles[roleID]; ok && rol.Position > botRank {
+			botRank = rol.Position
+		}
+	}
+
+	if err := gctx.Inst().Redis.SetEX(gctx, stylingKey(gctx, guildID), "1", stylingTTL); err != nil {
+		zap.S().Warnw("failed to mark roles as being styled", "error", err)
+	}
+
+	z := zap.S().Named("rolesync").With("guild_id", guildID)
+	styled := []string{}
+
+	// The linked roles which the bot can manage, which are then reordered among themselves
+	managed := []linkedRole{}
+
+	for _, rol := range appRoles {
+		if rol.DiscordID == 0 {
+			continue
+		}
+
+		grole, ok := guildRoles[strconv.FormatUint(rol.DiscordID, 10)]
+		if !ok || grole.Managed || grole.Position >= botRank {
+			continue
+		}
+
+		managed = append(managed, linkedRole{app: rol, guild: grole})
+
+		if grole.Name == rol.Name && grole.Color == DiscordColor(rol.Color) {
+			continue
+		}
+
+		if _, err := dis.GuildRoleEdit(guildID, grole.ID, &discordgo.RoleParams{
+			Name:  rol.Name,
+			Color: utils.PointerOf(DiscordColor(rol.Color)),
+		}); err != nil {
+			return styled, err
+		}
+
+		styled = append(styled, rol.Name)
+	}
+
+	moved := reorder(managed)
+	if len(moved) > 0 {
+		positions := make([]*discordgo.Role, 0, len(moved))
+
+		for _, lr := range moved {
+			positions = append(positions, &discordgo.Role{
+				ID:       lr.guild.ID,
+				Position: lr.position,
+			})
+
+			if !utils.Contains(styled, lr.app.Name) {
+				styled = append(styled, lr.app.Name)
+			}
+		}
+
+		if _, err := dis.GuildRoleReorder(guildID, positions); err != nil {
+			return styled, err
+		}
+	}
+
+	z.Infow("linked roles styled", "styled", styled)
+
+	return styled, nil
+}
+
+// Styling returns whether the linked roles of a guild are currently being restyled by the bot
+func Styling(gctx global.Context, guildID string) bool {
+	v, _ := gctx.Inst().Redis.Get(gctx, stylingKey(gctx, guildID))
+
+	return v != ""
+}
+
+func stylingKey(gctx global.Context, guildID string) redis.Key {
+	return gctx.Inst().Redis.ComposeKey("compactdisc", "styling", guildID)
+}
+
+type linkedRole struct {
+	app   structures.Role
+	guild *discordgo.Role
+	// position is the position the discord role should be moved to
+	position int
+}
+
+// reorder assigns the positions currently held by the linked roles to them in the order of their 7TV roles,
+// so that they are ranked like on 7TV without moving any other role. The roles whose position changes are returned
+func reorder(roles []linkedRole) []linkedRole {
+	slots := make([]int, len(roles))
+	for i, lr := range roles {
+		slots[i] = lr.guild.Position
+	}
+
+	sort.Ints(slots)
+
+	sorted := make([]linkedRole, len(roles))
+	copy(sorted, roles)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].app.Position != sorted[j].app.Position {
+			return sorted[i].app.Position < sorted[j].app.Position
+		}
+
+		return sorted[i].guild.Position < sorted[j].guild.Position
+	})
+
+	moved := []linkedRole{}
+
+	for i, lr := range sorted {
+		if lr.guild.Position == slots[i] {
+			continue
+		}
+
+		lr.position = slots[i]
+		moved = append(moved, lr)
+	}
+
+	return moved
+}
+
+// Drift describes how a linked discord role differs from its 7TV role.
+// Its position is only compared with those of the other linked roles, as other roles don't have a rank on 7TV
+func Drift(appRoles []structures.Role, guildRoles map[string]*discordgo.Role, rol structures.Role, grole *discordgo.Role) []string {
+	result := []string{}
+
+	if grole.Name != rol.Name {
+		result = append(result, fmt.Sprintf("Its name is **%s** instead of **%s**", grole.Name, rol.Name))
+	}
+
+	if color := DiscordColor(rol.Color); grole.Color != color {
+		result = append(result, fmt.Sprintf("Its color is `#%06X` instead of `#%06X`", grole.Color, color))
+	}
+
+	for _, other := range appRoles {
+		if other.ID == rol.ID || other.DiscordID == 0 || other.Position == rol.Position {
+			continue
+		}
+
+		ogrole, ok := guildRoles[strconv.FormatUint(other.DiscordID, 10)]
+		if !ok || ogrole.Position == grole.Position {
+			continue
+		}
+
+		if above := rol.Position > other.Position; above != (grole.Position > ogrole.Position) {
+			relation := "below"
+			if !above {
+				relation = "above"
+			}
+
+			result = append(result, fmt.Sprintf("It is ranked %s **%s**, unlike on 7TV", relation, other.Name))
+		}
+	}
+
+	return result
+}
+
+// DiscordColor converts the RGBA color of a 7TV role to the RGB color of a discord role
+func DiscordColor(color int32) int {
+	return int(uint32(color) >> 8)
+}
@@ -0,0 +1,221 @@
+package rolesync
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/mongo"
+	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/compactdisc/internal/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNotLinked is returned when a 7TV user has no discord connection, or a discord user has no 7TV account
+	ErrNotLinked = errors.New("no 7TV account is linked to this discord account")
+	// ErrNotMember is returned when the discord account of a user is not a member of the guild
+	ErrNotMember = errors.New("the user is not a member of the guild")
+)
+
+// Sync updates the discord roles of a 7TV user's guild member to match their 7TV roles
+func Sync(gctx global.Context, ctx context.Context, user structures.User, revoke bool) (RoleDiff, error) {
+	appRoles, err := gctx.Inst().Roles.AppRoles(ctx)
+	if err != nil {
+		return RoleDiff{}, err
+	}
+
+	con, ind, _ := user.Connections.Discord()
+	if ind == -1 {
+		return RoleDiff{}, ErrNotLinked
+	}
+
+	dis := gctx.Inst().Discord.Session()
+	guildID := gctx.Config().Discord.GuildID
+
+	z := zap.S().Named("rolesync").With(
+		"user_id", user.ID.Hex(),
+		"guild_id", guildID,
+		"discord_id", con.ID,
+	)
+
+	member, err := dis.State.Member(guildID, con.ID)
+	if err != nil { // member is not in state, so we must fetch them
+		member, err = dis.GuildMember(guildID, con.ID)
+		if err == nil {
+			_ = dis.State.MemberAdd(member)
+		}
+	}
+
+	if err != nil {
+		z.Infow("user is not in the guild", "error", err)
+		return RoleDiff{}, ErrNotMember
+	}
+
+	botMember, err := dis.State.Member(guildID, dis.State.User.ID)
+	if err != nil {
+		z.Errorw("bot is not in the guild", "error", err)
+		return RoleDiff{}, err
+	}
+
+	guildRoles, err := gctx.Inst().Roles.GuildRoles(guildID)
+	if err != nil {
+		guildRoles = map[string]*discordgo.Role{}
+	}
+
+	diff := DiffRoles(appRoles, guildRoles, user.Roles, member.Roles, botMember.Roles, revoke)
+
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+		z.Info("user's roles are in sync")
+		return diff, nil
+	}
+
+	if _, err := dis.GuildMemberEdit(guildID, member.User.ID, &discordgo.GuildMemberParams{
+		Roles: &diff.Roles,
+	}); err != nil {
+		z.Errorw("failed to update discord roles", "error", err)
+		return diff, err
+	}
+
+	z.Infow("roles updated", "added", diff.Added, "removed", diff.Removed, "skipped", diff.Skipped)
+
+	return diff, nil
+}
+
+// LinkedUser returns the 7TV user linked to a discord account.
+// ErrNotLinked is only returned when there is no such user, other errors are returned as is
+func LinkedUser(gctx global.Context, ctx context.Context, discordID string) (structures.User, error) {
+	filter := bson.M{
+		"connections": bson.M{"$elemMatch": bson.M{
+			"platform": structures.UserConnectionPlatformDiscord,
+			"id":       discordID,
+		}},
+	}
+
+	// Whether the user exists is checked first, so that failing to query them isn't mistaken for them not existing
+	err := gctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if errors.Is(err, mongodriver.ErrNoDocuments) {
+		return structures.User{}, ErrNotLinked
+	} else if err != nil {
+		return structures.User{}, err
+	}
+
+	return gctx.Inst().Query.Users(ctx, filter).First()
+}
+
+// RoleDiff is the result of comparing a user's 7TV roles with their discord roles
+type RoleDiff struct {
+	// Roles is the final list of discord role IDs the member should have
+	Roles []string
+	// Added is the names of the discord roles that will be added
+	Added []string
+	// Removed is the names of the discord roles that will be removed
+	Removed []string
+	// Skipped is the names of the discord roles that should change but are above the bot or managed by an integration
+	Skipped []string
+}
+
+// DiffRoles computes which linked discord roles must be added to or removed from a member
+func DiffRoles(
+	appRoles []structures.Role,
+	guildRoles map[string]*discordgo.Role,
+	userRoles []structures.Role,
+	memberRoles []string,
+	botRoles []string,
+	revoke bool,
+) RoleDiff {
+	botRank := 0
+
+	for _, roleID := range botRoles {
+		if rol, ok := guildRoles[roleID]; ok && rol.Position > botRank {
+			botRank = rol.Position
+		}
+	}
+
+	userRoleIDs := make(map[primitive.ObjectID]struct{}, len(userRoles))
+	for _, rol := range userRoles {
+		userRoleIDs[rol.ID] = struct{}{}
+	}
+
+	memberRoleIDs := make(map[string]struct{}, len(memberRoles))
+	for _, roleID := range memberRoles {
+		memberRoleIDs[roleID] = struct{}{}
+	}
+
+	// Go through the app's roles and sync the member's discord roles with them
+	diff := RoleDiff{
+		Added:   []string{},
+		Removed: []string{},
+		Skipped: []string{},
+	}
+
+	for _, rol := range appRoles {
+		if rol.DiscordID == 0 {
+			continue // ignore, because the role is not linked to discord
+		}
+
+		roleID := strconv.FormatUint(rol.DiscordID, 10)
+		grole, ok := guildRoles[roleID]
+
+		if !ok {
+			continue // ignore, because the role is not in the guild
+		}
+
+		_, hasDiscordRole := memberRoleIDs[roleID]
+		editable := grole.Position < botRank && !grole.Managed
+
+		if _, ok := userRoleIDs[rol.ID]; !ok || revoke { // user does not have this role
+			if !hasDiscordRole {
+				continue // role is already absent in discord
+			}
+
+			if !editable {
+				diff.Skipped = append(diff.Skipped, grole.Name)
+				continue // ignore, because the bot cannot edit this role
+			}
+
+			// will remove the role from the discord member
+			delete(memberRoleIDs, roleID)
+
+			diff.Removed = append(diff.Removed, grole.Name)
+		} else { // user has this role
+			if hasDiscordRole {
+				continue // role is already attributed in discord
+			}
+
+			if !editable {
+				diff.Skipped = append(diff.Skipped, grole.Name)
+				continue // ignore, because the bot cannot edit this role
+			}
+
+			// will add the role to the discord member
+			memberRoleIDs[roleID] = struct{}{}
+			diff.Added = append(diff.Added, grole.Name)
+		}
+	}
+
+	// Preserve the member's original role order, then append the added roles
+	diff.Roles = make([]string, 0, len(memberRoleIDs))
+
+	for _, roleID := range memberRoles {
+		if _, ok := memberRoleIDs[roleID]; ok {
+			diff.Roles = append(diff.Roles, roleID)
+			delete(memberRoleIDs, roleID)
+		}
+	}
+
+	for _, rol := range appRoles {
+		roleID := strconv.FormatUint(rol.DiscordID, 10)
+		if _, ok := memberRoleIDs[roleID]; ok {
+			diff.Roles = append(diff.Roles, roleID)
+			delete(memberRoleIDs, roleID)
+		}
+	}
+
+	return diff
+}
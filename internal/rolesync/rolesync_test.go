@@ -0,0 +1,183 @@
+package rolesync
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/seventv/common/structures/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// roleFixture is a guild in which every 7TV role is linked to a discord role, with members holding half of them
+type roleFixture struct {
+	appRoles   []structures.Role
+	guildRoles map[string]*discordgo.Role
+	userRoles  []structures.Role
+	members    [][]string
+	botRoles   []string
+}
+
+func newRoleFixture(roles int, members int) roleFixture {
+	f := roleFixture{
+		guildRoles: make(map[string]*discordgo.Role, roles+1),
+		members:    make([][]string, members),
+	}
+
+	// The bot's role ranks above every linked role
+	f.guildRoles["1"] = &discordgo.Role{ID: "1", Position: roles + 1}
+	f.botRoles = []string{"1"}
+
+	for i := 0; i < roles; i++ {
+		discordID := uint64(1000 + i)
+		rol := structures.Role{
+			ID:        primitive.NewObjectID(),
+			Name:      fmt.Sprintf("role %d", i),
+			DiscordID: discordID,
+		}
+
+		f.appRoles = append(f.appRoles, rol)
+		f.guildRoles[strconv.FormatUint(discordID, 10)] = &discordgo.Role{
+			ID:       strconv.FormatUint(discordID, 10),
+			Name:     rol.Name,
+			Position: i + 1,
+		}
+
+		if i%2 == 0 {
+			f.userRoles = append(f.userRoles, rol)
+		}
+	}
+
+	for m := range f.members {
+		// Each member holds the odd roles, so that every role is either added or removed
+		for i := 1; i < roles; i += 2 {
+			f.members[m] = append(f.members[m], strconv.Itoa(1000+i))
+		}
+	}
+
+	return f
+}
+
+// linearDiffRoles is the diff as it was before set lookups, scanning slices for every membership check
+func linearDiffRoles(
+	appRoles []structures.Role,
+	guildRoles map[string]*discordgo.Role,
+	userRoles []structures.Role,
+	memberRoles []string,
+	botRoles []string,
+	revoke bool,
+) []string {
+	indexOf := func(s []string, v string) int {
+		for i, x := range s {
+			if x == v {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	botRank := 0
+
+	for _, rol := range guildRoles {
+		if indexOf(botRoles, rol.ID) != -1 && rol.Position > botRank {
+			botRank = rol.Position
+		}
+	}
+
+	finalRoles := make([]string, len(memberRoles))
+	copy(finalRoles, memberRoles)
+
+	userRoleIDs := make([]primitive.ObjectID, len(userRoles))
+	for i, rol := range userRoles {
+		userRoleIDs[i] = rol.ID
+	}
+
+	hasUserRole := func(id primitive.ObjectID) bool {
+		for _, x := range userRoleIDs {
+			if x == id {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, rol := range appRoles {
+		if rol.DiscordID == 0 {
+			continue
+		}
+
+		roleID := strconv.Itoa(int(rol.DiscordID))
+		grole, ok := guildRoles[roleID]
+
+		if !ok {
+			continue
+		}
+
+		if !hasUserRole(rol.ID) || revoke {
+			if grole.Position >= botRank || grole.Managed {
+				continue
+			}
+
+			if pos := indexOf(finalRoles, roleID); pos != -1 {
+				finalRoles = append(finalRoles[:pos], finalRoles[pos+1:]...)
+			}
+		} else if indexOf(finalRoles, roleID) == -1 {
+			finalRoles = append(finalRoles, roleID)
+		}
+	}
+
+	return finalRoles
+}
+
+func TestDiffRolesMatchesLinearDiff(t *testing.T) {
+	f := newRoleFixture(50, 1)
+
+	diff := DiffRoles(f.appRoles, f.guildRoles, f.userRoles, f.members[0], f.botRoles, false)
+	linear := linearDiffRoles(f.appRoles, f.guildRoles, f.userRoles, f.members[0], f.botRoles, false)
+
+	got := make(map[string]struct{}, len(diff.Roles))
+	for _, id := range diff.Roles {
+		got[id] = struct{}{}
+	}
+
+	if len(got) != len(linear) {
+		t.Fatalf("expected %d roles, got %d", len(linear), len(got))
+	}
+
+	for _, id := range linear {
+		if _, ok := got[id]; !ok {
+			t.Fatalf("expected role %s to be kept", id)
+		}
+	}
+}
+
+// BenchmarkDiffRoles syncs every member of a guild, comparing the set based diff with the former linear one
+func BenchmarkDiffRoles(b *testing.B) {
+	for _, size := range []struct{ roles, members int }{
+		{100, 1000},
+		{1000, 1000},
+		{2000, 2000},
+	} {
+		f := newRoleFixture(size.roles, size.members)
+		name := fmt.Sprintf("roles=%d/members=%d", size.roles, size.members)
+
+		b.Run("sets/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, memberRoles := range f.members {
+					_ = DiffRoles(f.appRoles, f.guildRoles, f.userRoles, memberRoles, f.botRoles, false)
+				}
+			}
+		})
+
+		b.Run("linear/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, memberRoles := range f.members {
+					_ = linearDiffRoles(f.appRoles, f.guildRoles, f.userRoles, memberRoles, f.botRoles, false)
+				}
+			}
+		})
+	}
+}
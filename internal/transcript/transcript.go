@@ -0,0 +1,193 @@
+package transcript
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Sort orders messages chronologically
+func Sort(msgs []*discordgo.Message) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp.Before(msgs[j].Timestamp)
+	})
+}
+
+// Text renders messages as a plain text transcript
+func Text(title string, msgs []*discordgo.Message) string {
+	sb := strings.Builder{}
+
+	sb.WriteString(title + "\n")
+	sb.WriteString(fmt.Sprintf("%d messages, generated at %s\n\n", len(msgs), time.Now().UTC().Format(time.RFC3339)))
+
+	for _, msg := range msgs {
+		sb.WriteString(fmt.Sprintf("[%s] %s (%s)", msg.Timestamp.UTC().Format(time.RFC3339), authorName(msg), authorID(msg)))
+
+		if msg.EditedTimestamp != nil {
+			sb.WriteString(" (edited)")
+		}
+
+		sb.WriteString("\n")
+
+		if msg.Content != "" {
+			sb.WriteString(msg.Content + "\n")
+		}
+
+		for _, a := range msg.Attachments {
+			sb.WriteString(fmt.Sprintf("<attachment: %s>\n", a.URL))
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+var htmlTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	},
+	"author": authorName,
+	"avatar": func(msg *discordgo.Message) string {
+		if msg.Author == nil {
+			return ""
+		}
+
+		return msg.Author.AvatarURL("64")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { background: #313338; color: #dbdee1; font-family: sans-serif; margin: 2em; }
+.message { display: flex; margin-bottom: 1em; }
+.avatar { width: 40px; height: 40px; border-radius: 50%; margin-right: 1em; }
+.author { font-weight: bold; color: #f2f3f5; }
+.time { color: #949ba4; font-size: 0.8em; margin-left: 0.5em; }
+.content { white-space: pre-wrap; margin-top: 0.2em; }
+a { color: #00a8fc; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>{{ len .Messages }} messages</p>
+{{ range .Messages }}
+<div class="message">
+<img class="avatar" src="{{ avatar . }}" alt="">
+<div>
+<span class="author">{{ author . }}</span><span class="time">{{ time .Timestamp }}{{ if .EditedTimestamp }} (edited){{ end }}</span>
+<div class="content">{{ .Content }}</div>
+{{ range .Attachments }}<div><a href="{{ .URL }}">{{ .Filename }}</a></div>{{ end }}
+</div>
+</div>
+{{ end }}
+</body>
+</html>
+`))
+
+// HTML renders messages as an html transcript
+func HTML(title string, msgs []*discordgo.Message) (string, error) {
+	buf := bytes.Buffer{}
+
+	if err := htmlTemplate.Execute(&buf, struct {
+		Title    string
+		Messages []*discordgo.Message
+	}{
+		Title:    title,
+		Messages: msgs,
+	}); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Files renders messages as text and html transcripts, ready to be uploaded
+func Files(name string, title string, msgs []*discordgo.Message) ([]*discordgo.File, error) {
+	h, err := HTML(title, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*discordgo.File{
+		{
+			Name:        name + ".txt",
+			ContentType: "text/plain",
+			Reader:      strings.NewReader(Text(title, msgs)),
+		},
+		{
+			Name:        name + ".html",
+			ContentType: "text/html",
+			Reader:      strings.NewReader(h),
+		},
+	}, nil
+}
+
+// Authors counts the messages sent by each author, ordered by count
+func Authors(msgs []*discordgo.Message) []AuthorCount {
+	counts := map[string]*AuthorCount{}
+	result := []*AuthorCount{}
+
+	for _, msg := range msgs {
+		id := authorID(msg)
+
+		ac, ok := counts[id]
+		if !ok {
+			ac = &AuthorCount{ID: id, Name: authorName(msg)}
+			counts[id] = ac
+			result = append(result, ac)
+		}
+
+		ac.Count++
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Count > result[j].Count
+	})
+
+	out := make([]AuthorCount, len(result))
+	for i, ac := range result {
+		out[i] = *ac
+	}
+
+	return out
+}
+
+type AuthorCount struct {
+	ID    string
+	Name  string
+	Count int
+}
+
+func authorName(msg *discordgo.Message) string {
+	if msg.Author == nil {
+		return "Unknown"
+	}
+
+	return msg.Author.String()
+}
+
+func authorID(msg *discordgo.Message) string {
+	if msg.Author == nil {
+		return ""
+	}
+
+	return msg.Author.ID
+}
+
+// Truncate shortens text to at most limit characters
+func Truncate(text string, limit int) string {
+	r := []rune(text)
+	if len(r) <= limit {
+		return text
+	}
+
+	return string(r[:limit-1]) + "…"
+}